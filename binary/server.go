@@ -0,0 +1,158 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package binary provides a codec using a compact binary framing.
+//
+// A request body is made of two frames: the method name, in dotted notation
+// as in "Service.Method", and the encoded params object. A response body is
+// made of two frames as well: the error message, empty on success, and the
+// encoded reply. Every frame is a uvarint length followed by that many
+// bytes. Params and replies are encoded as JSON by default.
+//
+// The codec is registered under a binary content type, e.g.:
+//
+//	s.RegisterCodec(binary.NewCodec(), "application/x-rpc-binary")
+//
+// Responses are written with the "application/x-rpc-binary" Content-Type,
+// unless the codec is registered under another type set with
+// Codec.SetContentType.
+package binary
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/shridarpatil/rpc"
+)
+
+var errFraming = errors.New("rpc: binary request ill-formed: truncated frame")
+
+// contentType is the default Content-Type of the responses.
+const contentType = "application/x-rpc-binary"
+
+// ----------------------------------------------------------------------------
+// Codec
+// ----------------------------------------------------------------------------
+
+// NewCodec returns a new binary Codec encoding params and replies as JSON.
+func NewCodec() *Codec {
+	return NewCodecWith(json.Marshal, json.Unmarshal)
+}
+
+// NewCodecWith returns a new binary Codec encoding params and replies with
+// the given functions, e.g. those of a protobuf or msgpack package.
+func NewCodecWith(marshal func(interface{}) ([]byte, error), unmarshal func([]byte, interface{}) error) *Codec {
+	return &Codec{marshal: marshal, unmarshal: unmarshal, contentType: contentType}
+}
+
+// Codec creates a CodecRequest to process each request.
+type Codec struct {
+	marshal     func(interface{}) ([]byte, error)
+	unmarshal   func([]byte, interface{}) error
+	contentType string
+}
+
+// SetContentType sets the Content-Type of the responses, which should be
+// the content type the codec is registered for. It defaults to
+// "application/x-rpc-binary".
+func (c *Codec) SetContentType(contentType string) {
+	c.contentType = contentType
+}
+
+// NewRequest returns a CodecRequest.
+func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
+	req := &CodecRequest{codec: c}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		req.err = err
+		return req
+	}
+	method, body, err := readFrame(body)
+	if err != nil {
+		req.err = err
+		return req
+	}
+	req.method = string(method)
+	req.params, _, req.err = readFrame(body)
+	return req
+}
+
+// readFrame returns the content of the frame starting b, followed by the
+// remaining bytes.
+func readFrame(b []byte) ([]byte, []byte, error) {
+	n, size := binary.Uvarint(b)
+	if size <= 0 || n > uint64(len(b)-size) {
+		return nil, nil, errFraming
+	}
+	b = b[size:]
+	return b[:n], b[n:], nil
+}
+
+// appendFrame appends a frame holding p to b.
+func appendFrame(b, p []byte) []byte {
+	var size [binary.MaxVarintLen64]byte
+	b = append(b, size[:binary.PutUvarint(size[:], uint64(len(p)))]...)
+	return append(b, p...)
+}
+
+// ----------------------------------------------------------------------------
+// CodecRequest
+// ----------------------------------------------------------------------------
+
+// CodecRequest decodes and encodes a single request.
+type CodecRequest struct {
+	codec  *Codec
+	method string
+	params []byte
+	err    error
+}
+
+// Method returns the RPC method for the current request.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (c *CodecRequest) Method() (string, error) {
+	if c.err != nil {
+		return "", c.err
+	}
+	if c.method == "" {
+		return "", errors.New("rpc: method name missing")
+	}
+	return c.method, nil
+}
+
+// ReadRequest fills the request object for the RPC method. Empty params
+// leave the args zero-valued.
+func (c *CodecRequest) ReadRequest(args interface{}) error {
+	if c.err == nil && len(c.params) > 0 {
+		c.err = c.codec.unmarshal(c.params, args)
+	}
+	return c.err
+}
+
+// WriteResponse encodes the response and writes it to the ResponseWriter.
+func (c *CodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
+	b, err := c.codec.marshal(reply)
+	if err != nil {
+		c.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	c.write(w, http.StatusOK, nil, b)
+}
+
+// WriteError encodes the error and writes it to the ResponseWriter using
+// the given HTTP status.
+func (c *CodecRequest) WriteError(w http.ResponseWriter, status int, err error) {
+	c.write(w, status, []byte(err.Error()), nil)
+}
+
+func (c *CodecRequest) write(w http.ResponseWriter, status int, msg, reply []byte) {
+	w.Header().Set("Content-Type", c.codec.contentType)
+	w.WriteHeader(status)
+	w.Write(appendFrame(appendFrame(nil, msg), reply))
+}
@@ -0,0 +1,148 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binary
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shridarpatil/rpc"
+)
+
+type Service1Request struct {
+	A int
+	B int
+}
+
+type Service1Response struct {
+	Result int
+}
+
+type Service1 struct{}
+
+func (t *Service1) Multiply(r *http.Request, req *Service1Request, res *Service1Response) error {
+	res.Result = req.A * req.B
+	return nil
+}
+
+// newServer returns a server serving Service1 with the given codec,
+// registered for contentType.
+func newServer(t *testing.T, codec *Codec, contentType string) *rpc.Server {
+	t.Helper()
+	s := rpc.NewServer()
+	s.RegisterCodec(codec, contentType)
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// execute posts a binary request made of the given frames.
+func execute(s http.Handler, contentType string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/rpc", bytes.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+// frames encodes a request body holding the method and params frames.
+func frames(method, params string) []byte {
+	return appendFrame(appendFrame(nil, []byte(method)), []byte(params))
+}
+
+// readResponse decodes the frames of a response body.
+func readResponse(t *testing.T, w *httptest.ResponseRecorder) (string, []byte) {
+	t.Helper()
+	msg, rest, err := readFrame(w.Body.Bytes())
+	if err != nil {
+		t.Fatalf("response %q: %v", w.Body.Bytes(), err)
+	}
+	reply, rest, err := readFrame(rest)
+	if err != nil || len(rest) != 0 {
+		t.Fatalf("response %q: %v", w.Body.Bytes(), err)
+	}
+	return string(msg), reply
+}
+
+func TestService(t *testing.T) {
+	s := newServer(t, NewCodec(), contentType)
+	w := execute(s, contentType, frames("Service1.Multiply", `{"A":4,"B":2}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != contentType {
+		t.Errorf("Content-Type = %q, want %q", ct, contentType)
+	}
+	msg, reply := readResponse(t, w)
+	if msg != "" || string(reply) != `{"Result":8}` {
+		t.Errorf("error = %q, reply = %s", msg, reply)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	codec := NewCodec()
+	codec.SetContentType("application/vnd.example.rpc")
+	s := newServer(t, codec, "application/vnd.example.rpc")
+	w := execute(s, "application/vnd.example.rpc", frames("Service1.Multiply", `{"A":4,"B":2}`))
+	if ct := w.Header().Get("Content-Type"); ct != "application/vnd.example.rpc" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	s := newServer(t, NewCodec(), contentType)
+	tests := []struct {
+		body []byte
+		msg  string
+	}{
+		{frames("Service1.Divide", ""), `rpc: can't find method "Service1.Divide"`},
+		{frames("", ""), "rpc: method name missing"},
+		{[]byte{10, 'a'}, errFraming.Error()},
+		{frames("Service1.Multiply", `{"A":"x"}`), "cannot unmarshal"},
+	}
+	for _, test := range tests {
+		w := execute(s, contentType, test.body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+		}
+		msg, reply := readResponse(t, w)
+		if !bytes.Contains([]byte(msg), []byte(test.msg)) || len(reply) != 0 {
+			t.Errorf("error = %q, want %q; reply = %q", msg, test.msg, reply)
+		}
+	}
+}
+
+func TestEmptyParams(t *testing.T) {
+	s := newServer(t, NewCodec(), contentType)
+	w := execute(s, contentType, frames("Service1.Multiply", ""))
+	_, reply := readResponse(t, w)
+	if string(reply) != `{"Result":0}` {
+		t.Errorf("reply = %s", reply)
+	}
+}
+
+func TestCodecWith(t *testing.T) {
+	var marshaled, unmarshaled int
+	codec := NewCodecWith(
+		func(v interface{}) ([]byte, error) {
+			marshaled++
+			return json.Marshal(v)
+		},
+		func(b []byte, v interface{}) error {
+			unmarshaled++
+			return json.Unmarshal(b, v)
+		},
+	)
+	s := newServer(t, codec, contentType)
+	execute(s, contentType, frames("Service1.Multiply", `{"A":4,"B":2}`))
+	if marshaled != 1 || unmarshaled != 1 {
+		t.Errorf("marshaled = %d, unmarshaled = %d, want 1 each", marshaled, unmarshaled)
+	}
+}
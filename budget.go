@@ -0,0 +1,96 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrTotalBytesExceeded is returned when a request and its response exceed
+// the budget set with SetMaxTotalBytes.
+var ErrTotalBytesExceeded = errors.New("rpc: request and response exceed the total size budget")
+
+// SetMaxTotalBytes limits the combined size of the body of each request and
+// of its response, to share the server fairly. Requests whose body alone
+// exceeds it are rejected with http.StatusRequestEntityTooLarge. Responses
+// that would exceed what is left of it are aborted: if nothing was written
+// yet the client gets an empty http.StatusInternalServerError response,
+// otherwise the response is truncated. Zero means no limit, the default.
+func (s *Server) SetMaxTotalBytes(n int64) {
+	s.maxTotalBytes = n
+}
+
+// budgetReader fails reads once the request body exceeds the budget.
+type budgetReader struct {
+	*countingReader
+	max int64
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	n, err := b.countingReader.Read(p)
+	if int64(b.countingReader.n) > b.max {
+		return n, ErrTotalBytesExceeded
+	}
+	return n, err
+}
+
+// budgetResponse aborts the response once the request and response bodies
+// exceed the budget. The status is held back until the first write, so that
+// it can still be replaced when that write is over budget.
+type budgetResponse struct {
+	http.ResponseWriter
+	body     *countingReader
+	max      int64
+	n        int64
+	status   int
+	wrote    bool
+	exceeded bool
+}
+
+func (b *budgetResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *budgetResponse) Write(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, ErrTotalBytesExceeded
+	}
+	// Requests over budget on their own are rejected with a
+	// http.StatusRequestEntityTooLarge reply, which is let through.
+	if read := int64(b.body.n); read <= b.max && read+b.n+int64(len(p)) > b.max {
+		b.exceeded = true
+		if !b.wrote {
+			b.wrote = true
+			b.ResponseWriter.Header().Del("Content-Type")
+			b.ResponseWriter.WriteHeader(http.StatusInternalServerError)
+		}
+		return 0, ErrTotalBytesExceeded
+	}
+	b.flush()
+	n, err := b.ResponseWriter.Write(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// Flush flushes the wrapped ResponseWriter, if supported, for streamed
+// replies.
+func (b *budgetResponse) Flush() {
+	b.flush()
+	if f, ok := b.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// flush writes the status held back, if any.
+func (b *budgetResponse) flush() {
+	if !b.wrote && b.status != 0 {
+		b.wrote = true
+		b.ResponseWriter.WriteHeader(b.status)
+	}
+}
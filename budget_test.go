@@ -0,0 +1,48 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/shridarpatil/rpc"
+)
+
+func TestMaxTotalBytes(t *testing.T) {
+	s := newServer(t)
+	s.SetMaxTotalBytes(200)
+	var info rpc.RequestInfo
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		info = *i
+	})
+	expectResult(t, call(s, "HelloService.Say", []HelloArgs{{Who: "a"}}), `{"Message":"Hello, a!"}`)
+
+	// The request fits, but not along with its response.
+	w := call(s, "HelloService.Say", []HelloArgs{{Who: strings.Repeat("a", 100)}})
+	expect(t, w, http.StatusInternalServerError)
+	if w.Body.Len() != 0 {
+		t.Errorf("body = %q, want empty", w.Body.String())
+	}
+	if info.StatusCode != http.StatusInternalServerError || !errors.Is(info.Error, rpc.ErrTotalBytesExceeded) {
+		t.Errorf("after func status = %d, error = %v", info.StatusCode, info.Error)
+	}
+
+	// The request alone is over budget.
+	w = call(s, "HelloService.Say", []HelloArgs{{Who: strings.Repeat("a", 300)}})
+	expect(t, w, http.StatusRequestEntityTooLarge)
+	if !strings.Contains(w.Body.String(), rpc.ErrTotalBytesExceeded.Error()) {
+		t.Errorf("body = %q", w.Body.String())
+	}
+}
+
+func TestMaxTotalBytesUnlimited(t *testing.T) {
+	s := newServer(t)
+	who := strings.Repeat("a", 1000)
+	expectResult(t, call(s, "HelloService.Say", []HelloArgs{{Who: who}}), `{"Message":"Hello, `+who+`!"}`)
+}
@@ -0,0 +1,72 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ChaosOptions configures the latency injected by Server.EnableChaos.
+type ChaosOptions struct {
+	// Probability is the fraction of requests delayed, from 0 to 1.
+	Probability float64
+	// MinLatency and MaxLatency bound the injected delay, picked uniformly.
+	MinLatency time.Duration
+	MaxLatency time.Duration
+	// Seed seeds the random source deciding which requests are delayed and
+	// by how much, for reproducible runs. Zero seeds it with the time.
+	Seed int64
+}
+
+// chaos injects latency into requests.
+type chaos struct {
+	opts  ChaosOptions
+	mutex sync.Mutex
+	rand  *rand.Rand
+}
+
+// EnableChaos makes the server delay a random subset of the requests before
+// calling their method, to test how clients cope with a slow server, e.g.
+// their timeouts and retries. It is meant for test environments only and is
+// disabled by default.
+func (s *Server) EnableChaos(opts ChaosOptions) {
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	s.chaos = &chaos{opts: opts, rand: rand.New(rand.NewSource(seed))}
+}
+
+// delay returns the delay to inject into a request, possibly zero.
+func (c *chaos) delay() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.rand.Float64() >= c.opts.Probability {
+		return 0
+	}
+	d := c.opts.MinLatency
+	if spread := c.opts.MaxLatency - c.opts.MinLatency; spread > 0 {
+		d += time.Duration(c.rand.Int63n(int64(spread) + 1))
+	}
+	return d
+}
+
+// inject delays a request, unless its context is done first.
+func (c *chaos) inject(ctx context.Context) {
+	d := c.delay()
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shridarpatil/rpc"
+)
+
+func TestChaos(t *testing.T) {
+	s := newServer(t)
+	s.EnableChaos(rpc.ChaosOptions{
+		Probability: 1,
+		MinLatency:  20 * time.Millisecond,
+		MaxLatency:  40 * time.Millisecond,
+		Seed:        1,
+	})
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		w := call(s, "HelloService.Say", []HelloArgs{{Who: "a"}})
+		if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+			t.Errorf("request served in %v, want at least 20ms", elapsed)
+		}
+		expectResult(t, w, `{"Message":"Hello, a!"}`)
+	}
+}
+
+func TestChaosNeverDelays(t *testing.T) {
+	s := newServer(t)
+	s.EnableChaos(rpc.ChaosOptions{Probability: 0, MinLatency: time.Hour, MaxLatency: time.Hour})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		expectResult(t, call(s, "HelloService.Say", []HelloArgs{{Who: "a"}}), `{"Message":"Hello, a!"}`)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request delayed with a zero probability")
+	}
+}
+
+func TestChaosClientGone(t *testing.T) {
+	s := newServer(t)
+	s.EnableChaos(rpc.ChaosOptions{Probability: 1, MinLatency: time.Hour, MaxLatency: time.Hour})
+	var info rpc.RequestInfo
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		info = *i
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	r := serveRequest("POST", "/rpc", `{"method":"HelloService.Say","params":[{}]}`).WithContext(ctx)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serveHTTP(s, r)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("delay not cut short by the client going away")
+	}
+	if info.StatusCode != rpc.StatusClientClosedRequest {
+		t.Errorf("status = %d, want %d", info.StatusCode, rpc.StatusClientClosedRequest)
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/shridarpatil/rpc"
+	rpcjson "github.com/shridarpatil/rpc/json"
+)
+
+// ----------------------------------------------------------------------------
+// textCodec
+// ----------------------------------------------------------------------------
+
+// textCodec is a plain text codec whose GET requests name the method in the
+// "call" query parameter and set HelloArgs.Who from "who".
+type textCodec struct{}
+
+func (c textCodec) NewRequest(r *http.Request) rpc.CodecRequest {
+	return &textCodecRequest{err: fmt.Errorf("text: only GET is supported")}
+}
+
+func (c textCodec) NewGETRequest(r *http.Request) rpc.CodecRequest {
+	q := r.URL.Query()
+	return &textCodecRequest{method: q.Get("call"), who: q.Get("who")}
+}
+
+type textCodecRequest struct {
+	method string
+	who    string
+	err    error
+}
+
+func (c *textCodecRequest) Method() (string, error) {
+	return c.method, c.err
+}
+
+func (c *textCodecRequest) ReadRequest(args interface{}) error {
+	hello, ok := args.(*HelloArgs)
+	if !ok {
+		return fmt.Errorf("text: unsupported args %T", args)
+	}
+	hello.Who = c.who
+	return nil
+}
+
+func (c *textCodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, reply.(*HelloReply).Message)
+}
+
+func (c *textCodecRequest) WriteError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(status)
+	fmt.Fprint(w, err.Error())
+}
+
+func TestGETCodec(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(textCodec{}, "text/plain")
+	if err := s.RegisterService(new(HelloService), ""); err != nil {
+		t.Fatal(err)
+	}
+	// GET is opt-in.
+	w := serve(s, "GET", "/rpc?call=HelloService.Say&who=gopher", "")
+	expect(t, w, http.StatusMethodNotAllowed)
+
+	s.SetQueryParamMethods("GET")
+	w = serve(s, "GET", "/rpc?call=HelloService.Say&who=gopher", "")
+	expect(t, w, http.StatusOK)
+	if body := w.Body.String(); body != "Hello, gopher!" {
+		t.Errorf("body = %q, want %q", body, "Hello, gopher!")
+	}
+}
+
+func TestGETCodecJSON(t *testing.T) {
+	s := newServer(t)
+	s.SetQueryParamMethods("GET")
+	w := serve(s, "GET", "/rpc?method=HelloService.Say&Who=gopher", "")
+	expectResult(t, w, `{"Message":"Hello, gopher!"}`)
+}
+
+// ----------------------------------------------------------------------------
+// cachingCodec
+// ----------------------------------------------------------------------------
+
+// cachingCodec wraps the JSON codec, answering the requests carrying the
+// "X-Cached" header with a cached reply.
+type cachingCodec struct {
+	rpc.Codec
+}
+
+func (c cachingCodec) NewRequest(r *http.Request) rpc.CodecRequest {
+	return &cachingCodecRequest{CodecRequest: c.Codec.NewRequest(r), cached: r.Header.Get("X-Cached")}
+}
+
+type cachingCodecRequest struct {
+	rpc.CodecRequest
+	cached string
+}
+
+func (c *cachingCodecRequest) CachedResponse() (interface{}, bool) {
+	if c.cached == "" {
+		return nil, false
+	}
+	return &HelloReply{Message: c.cached}, true
+}
+
+func TestCachedResponder(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(cachingCodec{rpcjson.NewCodec()}, "application/json")
+	if err := s.RegisterService(new(HelloService), ""); err != nil {
+		t.Fatal(err)
+	}
+	var before, invoked int
+	s.RegisterBeforeFunc(func(i *rpc.RequestInfo) {
+		before++
+	})
+	s.UseInvocation(func(next rpc.InvokeFunc) rpc.InvokeFunc {
+		return func(ctx context.Context, args, reply interface{}) error {
+			invoked++
+			return next(ctx, args, reply)
+		}
+	})
+	var status int
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		status = i.StatusCode
+	})
+	r := serveRequest("POST", "/rpc", `{"method":"HelloService.Say","params":[{"Who":"a"}]}`)
+	r.Header.Set("X-Cached", "from cache")
+	expectResult(t, serveHTTP(s, r), `{"Message":"from cache"}`)
+	if invoked != 0 {
+		t.Error("method invoked for a cached response")
+	}
+	if before != 1 {
+		t.Errorf("before = %d, want 1", before)
+	}
+	if status != http.StatusOK {
+		t.Errorf("status = %d, want %d", status, http.StatusOK)
+	}
+	expectResult(t, call(s, "HelloService.Say", []HelloArgs{{Who: "a"}}), `{"Message":"Hello, a!"}`)
+	if invoked != 1 {
+		t.Errorf("invoked = %d, want 1", invoked)
+	}
+	if s.Stats()["HelloService.Say"].Calls != 2 {
+		t.Errorf("calls = %d, want 2", s.Stats()["HelloService.Say"].Calls)
+	}
+}
+
+func TestCachedResponderValidation(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(cachingCodec{rpcjson.NewCodec()}, "application/json")
+	if err := s.RegisterService(new(HelloService), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterValidateRequestFunc(func(i *rpc.RequestInfo, args interface{}) error {
+		if i.Request.Header.Get("Authorization") == "" {
+			return fmt.Errorf("unauthorized")
+		}
+		return nil
+	})
+	s.SetValidationErrorStatus(http.StatusUnauthorized)
+
+	// Cached responses are only served to accepted requests.
+	for _, authorization := range []string{"", "Bearer token"} {
+		r := serveRequest("POST", "/rpc", `{"method":"HelloService.Say","params":[{"Who":"a"}]}`)
+		r.Header.Set("X-Cached", "from cache")
+		if authorization == "" {
+			expectError(t, serveHTTP(s, r), http.StatusUnauthorized, "unauthorized")
+			continue
+		}
+		r.Header.Set("Authorization", authorization)
+		expectResult(t, serveHTTP(s, r), `{"Message":"from cache"}`)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Accepted error codec
+// ----------------------------------------------------------------------------
+
+// errorTextCodec is a textCodec also writing errors without a request.
+type errorTextCodec struct {
+	textCodec
+}
+
+func (c errorTextCodec) WriteError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(status)
+	fmt.Fprint(w, "error: "+err.Error())
+}
+
+func TestAcceptedErrorCodec(t *testing.T) {
+	s := newServer(t)
+	s.RegisterCodec(errorTextCodec{}, "text/plain")
+
+	// Early errors.
+	r := serveRequest("POST", "/rpc", "a,b")
+	r.Header.Set("Content-Type", "text/csv")
+	r.Header.Set("Accept", "application/json")
+	w := serveHTTP(s, r)
+	expect(t, w, http.StatusUnsupportedMediaType)
+	if res := decode(t, w); !strings.Contains(string(res.Error), "unrecognized Content-Type") {
+		t.Errorf("error = %s", res.Error)
+	}
+
+	r.Header.Set("Accept", "text/html, text/plain;q=0.9")
+	w = serveHTTP(s, r)
+	expectError(t, w, http.StatusUnsupportedMediaType, "error: rpc: unrecognized Content-Type")
+
+	// Errors of a decoded request.
+	r = serveRequest("POST", "/rpc", `{"method":"HelloService.Missing","params":[{}]}`)
+	r.Header.Set("Accept", "text/plain")
+	w = serveHTTP(s, r)
+	expectError(t, w, http.StatusBadRequest, "error: rpc: can't find method")
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	// Without Accept, the codec of the request writes them.
+	w = call(s, "HelloService.Missing", []HelloArgs{{}})
+	expect(t, w, http.StatusBadRequest)
+	if res := decode(t, w); !strings.Contains(string(res.Error), "method not found") {
+		t.Errorf("error = %s", res.Error)
+	}
+}
+
+func TestGETDefaultCodec(t *testing.T) {
+	s := newServer(t)
+	s.RegisterCodec(textCodec{}, "text/plain")
+	s.SetQueryParamMethods("GET")
+	url := "/rpc?method=HelloService.Say&call=HelloService.Say&who=a"
+
+	// Several codecs support GET.
+	expectError(t, serve(s, "GET", url, ""), http.StatusUnsupportedMediaType, "Content-Type required to select a GET codec")
+
+	s.SetGETDefaultCodec("Text/Plain")
+	w := serve(s, "GET", url, "")
+	expect(t, w, http.StatusOK)
+	if body := w.Body.String(); body != "Hello, a!" {
+		t.Errorf("body = %q", body)
+	}
+	// A Content-Type still selects the codec.
+	r := serveRequest("GET", url, "")
+	r.Header.Set("Content-Type", "application/json")
+	w = serveHTTP(s, r)
+	expectResult(t, w, `{"Message":"Hello, a!"}`)
+	if ct := w.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	s.SetGETDefaultCodec("application/xml")
+	expectError(t, serve(s, "GET", url, ""), http.StatusInternalServerError, "default GET codec not registered: application/xml")
+}
+
+func TestCodecSelector(t *testing.T) {
+	s := rpc.NewServer()
+	codec := rpcjson.NewCodec()
+	s.RegisterCodec(codec, "application/json")
+	if err := s.RegisterService(new(ContentTypeService), ""); err != nil {
+		t.Fatal(err)
+	}
+	unregistered := rpcjson.NewCodec()
+	s.SetCodecSelector(func(r *http.Request) rpc.Codec {
+		switch r.Header.Get("Content-Type") {
+		case "application/vnd.myapp.v2+json":
+			return codec
+		case "application/vnd.myapp.v3+json":
+			return unregistered
+		}
+		return nil
+	})
+	body := `{"method":"ContentTypeService.Get","params":[{}]}`
+	tests := []struct {
+		contentType string
+		want        string
+	}{
+		// The content type the selected codec is registered for.
+		{"application/vnd.myapp.v2+json", "application/json"},
+		// The Content-Type of the request for unregistered codecs.
+		{"application/vnd.myapp.v3+json", "application/vnd.myapp.v3+json"},
+		// Selection by Content-Type when the selector returns nil.
+		{"application/json", "application/json"},
+	}
+	for _, test := range tests {
+		r := serveRequest("POST", "/rpc", body)
+		r.Header.Set("Content-Type", test.contentType)
+		expectResult(t, serveHTTP(s, r), `{"Message":"`+test.want+`"}`)
+	}
+	r := serveRequest("POST", "/rpc", body)
+	r.Header.Set("Content-Type", "application/vnd.myapp.v1+json")
+	expect(t, serveHTTP(s, r), http.StatusUnsupportedMediaType)
+}
@@ -0,0 +1,92 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Validate checks the consistency of the registered services and routes,
+// once all of them are set up, e.g. in a test. It returns an error for each
+// problem found:
+//
+//   - services unreachable with the case policy set with SetCasePolicy;
+//   - resources set with MapResource mapped to unregistered methods;
+//   - services mounted with MountService that are not registered;
+//   - methods of a mounted service shadowed by the base path of another
+//     mounted service, as with "/users/" and "/users/admin/";
+//   - path templates set with SetMethodPathTemplate for unregistered or
+//     unmounted methods, or binding the same name twice.
+//
+// Requests are served the same way whatever Validate reports.
+func (s *Server) Validate() []error {
+	var errs []error
+	if s.casePolicy != CaseExact {
+		for _, name := range s.services.serviceNames() {
+			if first, _ := utf8.DecodeRuneInString(name); !unicode.IsUpper(first) && name != introspectionService {
+				errs = append(errs, fmt.Errorf("rpc: service %q is unreachable with the case policy", name))
+			}
+		}
+	}
+	for _, resource := range sortedKeys(s.resources) {
+		verbs := s.resources[resource]
+		for _, verb := range sortedKeys(verbs) {
+			if _, _, err := s.services.get(verbs[verb]); err != nil {
+				errs = append(errs, fmt.Errorf("rpc: resource %q maps %s to unregistered method %q", resource, verb, verbs[verb]))
+			}
+		}
+	}
+	mounted := make(map[string]bool)
+	bases := sortedKeys(s.mounts)
+	for _, base := range bases {
+		service := s.mounts[base]
+		mounted[service] = true
+		if !s.services.has(service) {
+			errs = append(errs, fmt.Errorf("rpc: service %q mounted at %q is not registered", service, base))
+			continue
+		}
+		for _, other := range bases {
+			if other == base || !strings.HasPrefix(other, base) {
+				continue
+			}
+			segment := strings.SplitN(other[len(base):], "/", 2)[0]
+			first, size := utf8.DecodeRuneInString(segment)
+			method := service + "." + string(unicode.ToUpper(first)) + segment[size:]
+			if _, _, err := s.services.get(method); err == nil {
+				errs = append(errs, fmt.Errorf("rpc: method %q is shadowed by the service mounted at %q", method, other))
+			}
+		}
+	}
+	for _, method := range sortedKeys(s.pathTemplates) {
+		if _, _, err := s.services.get(method); err != nil {
+			errs = append(errs, fmt.Errorf("rpc: path template set for unregistered method %q", method))
+		} else if !mounted[strings.Split(method, ".")[0]] {
+			errs = append(errs, fmt.Errorf("rpc: path template set for method %q of an unmounted service", method))
+		}
+		seen := make(map[string]bool)
+		for _, name := range s.pathTemplates[method] {
+			if seen[name] {
+				errs = append(errs, fmt.Errorf("rpc: path template of method %q binds %q twice", method, name))
+			}
+			seen[name] = true
+		}
+	}
+	return errs
+}
+
+// sortedKeys returns the keys of a map keyed by strings, sorted.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
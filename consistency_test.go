@@ -0,0 +1,73 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shridarpatil/rpc"
+)
+
+func TestServerValidate(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(ItemService), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.MapResource("item", map[string]string{"GET": "ItemService.List"})
+	s.MountService("ItemService", "/items/")
+	if err := s.SetMethodPathTemplate("ItemService.List", "{name}"); err != nil {
+		t.Fatal(err)
+	}
+	if errs := s.Validate(); len(errs) != 0 {
+		t.Errorf("errors = %v, want none", errs)
+	}
+}
+
+func TestServerValidateInconsistencies(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(ItemService), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterService(new(MemberService), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterService(new(HelloService), "ping"); err != nil {
+		t.Fatal(err)
+	}
+	s.SetCasePolicy(rpc.CaseTitleFirst)
+	s.MapResource("item", map[string]string{"DELETE": "ItemService.Delete"})
+	s.MountService("Ghost", "/ghost/")
+	s.MountService("ItemService", "/items/")
+	s.MountService("HelloService", "/items/list/")
+	for method, template := range map[string]string{
+		"ItemService.Delete": "{id}",
+		"MemberService.Get":  "{id}",
+		"ItemService.Create": "{id}/{id}",
+	} {
+		if err := s.SetMethodPathTemplate(method, template); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		`service "ping" is unreachable with the case policy`,
+		`resource "item" maps DELETE to unregistered method "ItemService.Delete"`,
+		`service "Ghost" mounted at "/ghost/" is not registered`,
+		`method "ItemService.List" is shadowed by the service mounted at "/items/list/"`,
+		`path template of method "ItemService.Create" binds "id" twice`,
+		`path template set for unregistered method "ItemService.Delete"`,
+		`path template set for method "MemberService.Get" of an unmounted service`,
+	}
+	errs := s.Validate()
+	if len(errs) != len(want) {
+		t.Fatalf("errors = %v, want %d", errs, len(want))
+	}
+	for i, err := range errs {
+		if !strings.Contains(err.Error(), want[i]) {
+			t.Errorf("error %d = %q, want %q", i, err, want[i])
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// contextKey is the type of the keys of the values the server stores in
+// the request context.
+type contextKey int
+
+const (
+	warningsKey contextKey = iota
+	unmatchedMethodKey
+	flagsKey
+	fieldsKey
+	contentTypeKey
+	cacheControlKey
+	requestIDKey
+	pathParamsKey
+	traceContextKey
+)
+
+// warnings collects the warnings added while serving a request.
+type warnings struct {
+	mutex sync.Mutex
+	list  []string
+}
+
+// cacheControl collects the Cache-Control directives set while serving a
+// request.
+type cacheControl struct {
+	mutex      sync.Mutex
+	directives []string
+}
+
+// AddWarning attaches a warning to the response of the request whose context
+// is ctx. Codecs include the warnings in successful responses, keeping the
+// call successful while informing the client. It does nothing if ctx does
+// not come from a request served by a Server.
+func AddWarning(ctx context.Context, msg string) {
+	if w, ok := ctx.Value(warningsKey).(*warnings); ok {
+		w.mutex.Lock()
+		w.list = append(w.list, msg)
+		w.mutex.Unlock()
+	}
+}
+
+// Warnings returns the warnings attached to the request whose context is
+// ctx.
+func Warnings(ctx context.Context) []string {
+	w, ok := ctx.Value(warningsKey).(*warnings)
+	if !ok {
+		return nil
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return append([]string(nil), w.list...)
+}
+
+// UnmatchedMethod returns the method requested by the client when the request
+// is served by a catch-all method (see Server.RegisterCatchAll), or an empty
+// string otherwise.
+func UnmatchedMethod(ctx context.Context) string {
+	method, _ := ctx.Value(unmatchedMethodKey).(string)
+	return method
+}
+
+// Flags returns the feature flags evaluated for the request whose context is
+// ctx by the provider registered with Server.RegisterFlagProvider, or nil.
+func Flags(ctx context.Context) map[string]bool {
+	flags, _ := ctx.Value(flagsKey).(map[string]bool)
+	return flags
+}
+
+// SelectedFields returns the reply fields requested with the "fields" query
+// parameter, e.g. "?fields=id,name", when field selection is enabled with
+// Server.EnableFieldSelection. It returns nil if all the fields are to be
+// written.
+func SelectedFields(ctx context.Context) []string {
+	fields, _ := ctx.Value(fieldsKey).([]string)
+	return fields
+}
+
+// NegotiatedContentType returns the content type of the codec serving the
+// request whose context is ctx, e.g. "application/json", including when the
+// codec is selected by default for a request without a Content-Type.
+func NegotiatedContentType(ctx context.Context) string {
+	contentType, _ := ctx.Value(contentTypeKey).(string)
+	return contentType
+}
+
+// SetCacheControl adds a Cache-Control directive, e.g. "max-age=60" or
+// "private", to the response of the request whose context is ctx. The
+// directives are written on successful responses only. It does nothing if
+// ctx does not come from a request served by a Server.
+func SetCacheControl(ctx context.Context, directive string) {
+	if c, ok := ctx.Value(cacheControlKey).(*cacheControl); ok {
+		c.mutex.Lock()
+		c.directives = append(c.directives, directive)
+		c.mutex.Unlock()
+	}
+}
+
+// header returns the value of the Cache-Control header.
+func (c *cacheControl) header() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return strings.Join(c.directives, ", ")
+}
@@ -0,0 +1,270 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/shridarpatil/rpc"
+	rpcjson "github.com/shridarpatil/rpc/json"
+)
+
+// ----------------------------------------------------------------------------
+// Warnings
+// ----------------------------------------------------------------------------
+
+type WarnService struct{}
+
+func (s *WarnService) Say(r *http.Request, args *HelloArgs, reply *HelloReply) error {
+	if args.Who == "" {
+		rpc.AddWarning(r.Context(), "Who is empty")
+		rpc.AddWarning(r.Context(), "defaulting to world")
+		args.Who = "world"
+	}
+	reply.Message = "Hello, " + args.Who + "!"
+	return nil
+}
+
+func TestWarnings(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(WarnService), ""); err != nil {
+		t.Fatal(err)
+	}
+	w := call(s, "WarnService.Say", []HelloArgs{{}})
+	expectResult(t, w, `{"Message":"Hello, world!"}`)
+	want := []string{"Who is empty", "defaulting to world"}
+	if res := decode(t, w); !reflect.DeepEqual(res.Warnings, want) {
+		t.Errorf("warnings = %q, want %q", res.Warnings, want)
+	}
+	// Responses without warnings leave them out.
+	w = call(s, "WarnService.Say", []HelloArgs{{Who: "a"}})
+	expectResult(t, w, `{"Message":"Hello, a!"}`)
+	if res := decode(t, w); res.Warnings != nil {
+		t.Errorf("warnings = %q, want none", res.Warnings)
+	}
+}
+
+func TestAddWarningOutsideServer(t *testing.T) {
+	ctx := context.Background()
+	rpc.AddWarning(ctx, "ignored")
+	if warnings := rpc.Warnings(ctx); warnings != nil {
+		t.Errorf("warnings = %q, want none", warnings)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Catch-all methods
+// ----------------------------------------------------------------------------
+
+type ProxyReply struct {
+	Method string
+	Params json.RawMessage
+}
+
+type ProxyService struct{}
+
+func (s *ProxyService) Forward(r *http.Request, args *json.RawMessage, reply *ProxyReply) error {
+	reply.Method = rpc.UnmatchedMethod(r.Context())
+	reply.Params = *args
+	return nil
+}
+
+func (s *ProxyService) Ping(r *http.Request, args *HelloArgs, reply *HelloReply) error {
+	reply.Message = "pong"
+	return nil
+}
+
+func TestCatchAll(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(ProxyService), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterCatchAll("ProxyService", "Unknown"); err == nil {
+		t.Error("unknown catch-all method accepted")
+	}
+	if err := s.RegisterCatchAll("ProxyService", "Forward"); err != nil {
+		t.Fatal(err)
+	}
+	expectResult(t, call(s, "ProxyService.Missing", map[string]int{"a": 1}), `{"Method":"ProxyService.Missing","Params":{"a":1}}`)
+	// Known methods are served as usual.
+	expectResult(t, call(s, "ProxyService.Ping", []HelloArgs{{}}), `{"Message":"pong"}`)
+	expectResult(t, call(s, "ProxyService.Forward", []int{1}), `{"Method":"","Params":[1]}`)
+	// Other services are not affected.
+	expectError(t, call(s, "HelloService.Missing", []HelloArgs{{}}), http.StatusBadRequest, "method not found")
+}
+
+// ----------------------------------------------------------------------------
+// Feature flags
+// ----------------------------------------------------------------------------
+
+type FlagService struct{}
+
+func (s *FlagService) Greet(r *http.Request, args *HelloArgs, reply *HelloReply) error {
+	reply.Message = "Hello"
+	if rpc.Flags(r.Context())["shout"] {
+		reply.Message = "HELLO"
+	}
+	return nil
+}
+
+func TestFlags(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(FlagService), ""); err != nil {
+		t.Fatal(err)
+	}
+	var evaluated int
+	shout := true
+	s.RegisterFlagProvider(func(r *http.Request) map[string]bool {
+		evaluated++
+		return map[string]bool{"shout": shout}
+	})
+	var beforeFlags, afterFlags map[string]bool
+	s.RegisterBeforeFunc(func(i *rpc.RequestInfo) {
+		beforeFlags = rpc.Flags(i.Request.Context())
+		// Flags are evaluated once: later changes don't apply to the
+		// request.
+		shout = false
+	})
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		afterFlags = rpc.Flags(i.Request.Context())
+	})
+	expectResult(t, call(s, "FlagService.Greet", []HelloArgs{{}}), `{"Message":"HELLO"}`)
+	if evaluated != 1 {
+		t.Errorf("evaluated = %d, want 1", evaluated)
+	}
+	if !beforeFlags["shout"] || !afterFlags["shout"] {
+		t.Errorf("before flags = %v, after flags = %v", beforeFlags, afterFlags)
+	}
+	if flags := rpc.Flags(context.Background()); flags != nil {
+		t.Errorf("flags = %v, want nil", flags)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Field selection
+// ----------------------------------------------------------------------------
+
+func TestFieldSelection(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(UserService), ""); err != nil {
+		t.Fatal(err)
+	}
+	body := `{"method":"UserService.Create","params":[{"name":"a"}]}`
+	// Disabled by default.
+	expectResult(t, serve(s, "POST", "/rpc?fields=name", body), `{"id":0,"name":"a","manager":null}`)
+
+	s.EnableFieldSelection()
+	expectResult(t, serve(s, "POST", "/rpc?fields=name", body), `{"name":"a"}`)
+	expectResult(t, serve(s, "POST", "/rpc?fields=id,name,unknown", body), `{"id":0,"name":"a"}`)
+	expectResult(t, serve(s, "POST", "/rpc", body), `{"id":0,"name":"a","manager":null}`)
+}
+
+func TestFieldSelectionGET(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(rpcjson.NewCodecDisallowUnknown(), "application/json")
+	if err := s.RegisterService(new(UserService), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.SetQueryParamMethods("GET")
+	url := "/rpc?method=UserService.Create&name=a&fields=name"
+	// Without field selection, fields is an unknown param.
+	expectError(t, serve(s, "GET", url, ""), http.StatusBadRequest, `unknown field \"fields\"`)
+
+	s.EnableFieldSelection()
+	expectResult(t, serve(s, "GET", url, ""), `{"name":"a"}`)
+}
+
+func TestSelectedFields(t *testing.T) {
+	s := newServer(t)
+	s.EnableFieldSelection()
+	var fields []string
+	s.RegisterBeforeFunc(func(i *rpc.RequestInfo) {
+		fields = rpc.SelectedFields(i.Request.Context())
+	})
+	serve(s, "POST", "/rpc?fields=a,b", `{"method":"HelloService.Say","params":[{}]}`)
+	if !reflect.DeepEqual(fields, []string{"a", "b"}) {
+		t.Errorf("fields = %q", fields)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Negotiated content type
+// ----------------------------------------------------------------------------
+
+type ContentTypeService struct{}
+
+func (s *ContentTypeService) Get(r *http.Request, args *HelloArgs, reply *HelloReply) error {
+	reply.Message = rpc.NegotiatedContentType(r.Context())
+	return nil
+}
+
+func TestNegotiatedContentType(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(ContentTypeService), ""); err != nil {
+		t.Fatal(err)
+	}
+	body := `{"method":"ContentTypeService.Get","params":[{}]}`
+	r := serveRequest("POST", "/rpc", body)
+	r.Header.Set("Content-Type", "Application/JSON; charset=utf-8")
+	expectResult(t, serveHTTP(s, r), `{"Message":"application/json"}`)
+	// The only codec serves requests without a Content-Type.
+	r = serveRequest("POST", "/rpc", body)
+	r.Header.Del("Content-Type")
+	expectResult(t, serveHTTP(s, r), `{"Message":"application/json"}`)
+	if ct := rpc.NegotiatedContentType(context.Background()); ct != "" {
+		t.Errorf("content type = %q, want none", ct)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Cache-Control
+// ----------------------------------------------------------------------------
+
+type CachedService struct{}
+
+func (s *CachedService) Get(r *http.Request, args *CacheArgs, reply *HelloReply) error {
+	rpc.SetCacheControl(r.Context(), "private")
+	rpc.SetCacheControl(r.Context(), "max-age=60")
+	switch args.Version {
+	case 1:
+		return errors.New("failed")
+	case 2:
+		return rpc.ErrNotModified
+	}
+	return nil
+}
+
+func TestSetCacheControl(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(CachedService), ""); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		version int
+		status  int
+		header  string
+	}{
+		{0, http.StatusOK, "private, max-age=60"},
+		{1, http.StatusBadRequest, ""},
+		{2, http.StatusNotModified, "private, max-age=60"},
+	}
+	for _, test := range tests {
+		w := call(s, "CachedService.Get", []CacheArgs{{Version: test.version}})
+		expect(t, w, test.status)
+		if header := w.Header().Get("Cache-Control"); header != test.header {
+			t.Errorf("version %d: Cache-Control = %q, want %q", test.version, header, test.header)
+		}
+	}
+	// Methods not setting directives leave the header out.
+	if header := call(s, "HelloService.Say", []HelloArgs{{}}).Header().Get("Cache-Control"); header != "" {
+		t.Errorf("Cache-Control = %q, want none", header)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package csv provides a codec writing tabular replies as CSV.
+//
+// The codec only encodes replies: it is registered for "text/csv" and used
+// for requests accepting that media type, while requests are decoded by
+// the codec selected from their Content-Type, e.g. JSON:
+//
+//	s.RegisterCodec(json.NewCodec(), "application/json")
+//	s.RegisterCodec(csv.NewCodec(), "text/csv")
+package csv
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/shridarpatil/rpc"
+)
+
+var errNoRequests = errors.New("csv: requests must be encoded with another codec")
+
+// ----------------------------------------------------------------------------
+// Codec
+// ----------------------------------------------------------------------------
+
+// NewCodec returns a new CSV Codec.
+func NewCodec() *Codec {
+	return &Codec{}
+}
+
+// Codec writes replies that are slices or arrays of structs, or pointers to
+// structs, as CSV. The header row holds the names of the exported fields,
+// following the "json" struct tags, and every element is written as a row.
+// Nil elements are skipped. The items of rpc.Paginated replies are written the same way.
+type Codec struct{}
+
+// NewRequest returns a CodecRequest rejecting the request, as requests are
+// not encoded as CSV.
+func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
+	return &CodecRequest{}
+}
+
+// WriteResponse encodes the reply as CSV and writes it to the
+// ResponseWriter. It returns an error if the reply is not tabular.
+func (c *Codec) WriteResponse(w http.ResponseWriter, reply interface{}) error {
+	if p, ok := reply.(rpc.Paginated); ok {
+		reply = p.Items()
+	}
+	v := reflect.ValueOf(reply)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return fmt.Errorf("csv: reply of type %T is not a slice", reply)
+	}
+	rowType := v.Type().Elem()
+	if rowType.Kind() == reflect.Ptr {
+		rowType = rowType.Elem()
+	}
+	if rowType.Kind() != reflect.Struct {
+		return fmt.Errorf("csv: reply of type %T is not a slice of structs", reply)
+	}
+	header, fields := columns(rowType)
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(header)
+	record := make([]string, len(fields))
+	for i := 0; i < v.Len(); i++ {
+		row := v.Index(i)
+		if row.Kind() == reflect.Ptr {
+			if row.IsNil() {
+				continue
+			}
+			row = row.Elem()
+		}
+		for j, field := range fields {
+			record[j] = cell(row.Field(field))
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+	return nil
+}
+
+// columns returns the header row of a struct type and the indexes of the
+// fields of the columns.
+func columns(t reflect.Type) ([]string, []int) {
+	var header []string
+	var fields []int
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if field.PkgPath != "" || tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		header = append(header, name)
+		fields = append(fields, i)
+	}
+	return header, fields
+}
+
+// cell formats a field value. Nil pointers are written as empty cells.
+func cell(v reflect.Value) string {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// ----------------------------------------------------------------------------
+// CodecRequest
+// ----------------------------------------------------------------------------
+
+// CodecRequest is returned for requests sent as CSV, which are not
+// supported.
+type CodecRequest struct{}
+
+// Method returns an error, as requests are not encoded as CSV.
+func (c *CodecRequest) Method() (string, error) {
+	return "", errNoRequests
+}
+
+// ReadRequest returns an error, as requests are not encoded as CSV.
+func (c *CodecRequest) ReadRequest(args interface{}) error {
+	return errNoRequests
+}
+
+// WriteResponse writes the reply as CSV, or an error if it is not tabular.
+func (c *CodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
+	if err := (&Codec{}).WriteResponse(w, reply); err != nil {
+		rpc.WriteError(w, http.StatusNotAcceptable, err.Error())
+	}
+}
+
+// WriteError writes the error as plain text.
+func (c *CodecRequest) WriteError(w http.ResponseWriter, status int, err error) {
+	rpc.WriteError(w, status, err.Error())
+}
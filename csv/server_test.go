@@ -0,0 +1,123 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package csv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shridarpatil/rpc"
+	"github.com/shridarpatil/rpc/json"
+)
+
+type Row struct {
+	Id     int     `json:"id"`
+	Name   string  `json:"name,omitempty"`
+	Note   *string `json:"note"`
+	Secret string  `json:"-"`
+	hidden string
+}
+
+type ListArgs struct{}
+
+type ListService struct{}
+
+func (s *ListService) Rows(r *http.Request, args *ListArgs, reply *[]*Row) error {
+	note := "b, quoted"
+	*reply = []*Row{{Id: 1, Name: "a", Note: &note, Secret: "s"}, nil, {Id: 2}}
+	return nil
+}
+
+func (s *ListService) Count(r *http.Request, args *ListArgs, reply *int) error {
+	*reply = 2
+	return nil
+}
+
+// newServer returns a server decoding JSON requests and writing CSV
+// replies.
+func newServer(t *testing.T) *rpc.Server {
+	t.Helper()
+	s := rpc.NewServer()
+	s.RegisterCodec(json.NewCodec(), "application/json")
+	s.RegisterCodec(NewCodec(), "text/csv")
+	if err := s.RegisterService(new(ListService), ""); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// execute serves a JSON request calling method, accepting the given media
+// type.
+func execute(s http.Handler, method, accept string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/rpc", strings.NewReader(`{"method":"`+method+`","params":[{}]}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Accept", accept)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestWriteResponse(t *testing.T) {
+	s := newServer(t)
+	w := execute(s, "ListService.Rows", "text/csv")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	want := "id,name,note\n1,a,\"b, quoted\"\n2,,\n"
+	if body := w.Body.String(); body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+
+	// Other clients get JSON.
+	w = execute(s, "ListService.Rows", "application/json")
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestWriteResponseNotTabular(t *testing.T) {
+	s := newServer(t)
+	w := execute(s, "ListService.Count", "text/csv")
+	if w.Code != http.StatusNotAcceptable {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusNotAcceptable)
+	}
+	if !strings.Contains(w.Body.String(), "is not a slice") {
+		t.Errorf("body = %q", w.Body.String())
+	}
+}
+
+type page struct {
+	rows []Row
+}
+
+func (p *page) Items() interface{} { return p.rows }
+func (p *page) NextCursor() string { return "next" }
+
+func TestWriteResponsePaginated(t *testing.T) {
+	w := httptest.NewRecorder()
+	if err := NewCodec().WriteResponse(w, &page{rows: []Row{{Id: 3, Name: "c"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if body, want := w.Body.String(), "id,name,note\n3,c,\n"; body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
+
+func TestRequestsRejected(t *testing.T) {
+	s := newServer(t)
+	r := httptest.NewRequest("POST", "/rpc", strings.NewReader("id\n1\n"))
+	r.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), errNoRequests.Error()) {
+		t.Errorf("status = %d, body = %q", w.Code, w.Body.String())
+	}
+}
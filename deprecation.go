@@ -0,0 +1,65 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// deprecation holds the deprecation of a service.
+type deprecation struct {
+	service string
+	message string
+	sunset  time.Time // zero if no sunset date is set
+}
+
+// DeprecateService marks a service as deprecated. The responses of its
+// methods carry a "Deprecation: true" header and, when sunsetDate is set, a
+// Sunset header (RFC 8594) with that date. A non-empty message is attached
+// to the responses as a warning (see AddWarning).
+//
+// The sunset date uses the "2006-01-02" layout, in UTC, and can be left
+// empty. Once it is reached, requests to the service are rejected with
+// http.StatusGone.
+func (s *Server) DeprecateService(name, message, sunsetDate string) error {
+	dep := &deprecation{service: name, message: message}
+	if sunsetDate != "" {
+		sunset, err := time.Parse("2006-01-02", sunsetDate)
+		if err != nil {
+			return fmt.Errorf("rpc: invalid sunset date %q: %v", sunsetDate, err)
+		}
+		dep.sunset = sunset
+	}
+	if s.deprecations == nil {
+		s.deprecations = make(map[string]*deprecation)
+	}
+	s.deprecations[name] = dep
+	return nil
+}
+
+// deprecated returns the deprecation of the service of a method, if any.
+func (s *Server) deprecated(method string) *deprecation {
+	if i := strings.Index(method, "."); i != -1 {
+		return s.deprecations[method[:i]]
+	}
+	return nil
+}
+
+// setHeaders sets the deprecation headers of a response.
+func (d *deprecation) setHeaders(h http.Header) {
+	h.Set("Deprecation", "true")
+	if !d.sunset.IsZero() {
+		h.Set("Sunset", d.sunset.Format(http.TimeFormat))
+	}
+}
+
+// gone returns true if the sunset date is reached.
+func (d *deprecation) gone(now time.Time) bool {
+	return !d.sunset.IsZero() && !now.Before(d.sunset)
+}
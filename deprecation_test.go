@@ -0,0 +1,72 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestDeprecateService(t *testing.T) {
+	s := newServer(t)
+	for _, name := range []string{"OldHello", "RetiredHello"} {
+		if err := s.RegisterService(new(HelloService), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.DeprecateService("OldHello", "use HelloService", "2999-01-01"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeprecateService("RetiredHello", "", "2000-01-01"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := call(s, "OldHello.Say", []HelloArgs{{Who: "a"}})
+	expectResult(t, w, `{"Message":"Hello, a!"}`)
+	if h := w.Header().Get("Deprecation"); h != "true" {
+		t.Errorf("Deprecation = %q", h)
+	}
+	if h := w.Header().Get("Sunset"); h != "Tue, 01 Jan 2999 00:00:00 GMT" {
+		t.Errorf("Sunset = %q", h)
+	}
+	if res := decode(t, w); !reflect.DeepEqual(res.Warnings, []string{"use HelloService"}) {
+		t.Errorf("warnings = %q", res.Warnings)
+	}
+
+	// Past the sunset date, the service is gone.
+	w = call(s, "RetiredHello.Say", []HelloArgs{{Who: "a"}})
+	expectError(t, w, http.StatusGone, "is retired")
+	if h := w.Header().Get("Deprecation"); h != "true" {
+		t.Errorf("Deprecation = %q", h)
+	}
+
+	w = call(s, "HelloService.Say", []HelloArgs{{Who: "a"}})
+	if h := w.Header().Get("Deprecation"); h != "" {
+		t.Errorf("Deprecation = %q, want none", h)
+	}
+}
+
+func TestDeprecateServiceWithoutSunset(t *testing.T) {
+	s := newServer(t)
+	if err := s.DeprecateService("HelloService", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	w := call(s, "HelloService.Say", []HelloArgs{{Who: "a"}})
+	expectResult(t, w, `{"Message":"Hello, a!"}`)
+	if h := w.Header().Get("Deprecation"); h != "true" {
+		t.Errorf("Deprecation = %q", h)
+	}
+	if _, ok := w.Header()["Sunset"]; ok {
+		t.Error("Sunset header set without a sunset date")
+	}
+	if res := decode(t, w); res.Warnings != nil {
+		t.Errorf("warnings = %q, want none", res.Warnings)
+	}
+	if err := s.DeprecateService("HelloService", "", "01/02/2006"); err == nil {
+		t.Error("invalid sunset date accepted")
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"net/http"
+	"reflect"
+)
+
+// Validate registers f as the validate function of the server for the
+// methods whose args are of type T, like Server.RegisterValidateRequestFunc,
+// with the args type checked at compile time. Functions registered for
+// different types are combined: each request is validated by the function
+// of its args type, and requests to methods of other args types are not
+// validated. A later call for the same type overwrites the previous one.
+//
+// Note: RegisterValidateRequestFunc overwrites the functions registered
+// with Validate, and the other way around.
+func Validate[T any](s *Server, f func(*RequestInfo, *T) error) {
+	if s.validators == nil {
+		s.validators = make(map[reflect.Type]func(r *RequestInfo, i interface{}) error)
+	}
+	s.validators[reflect.TypeOf((*T)(nil))] = func(r *RequestInfo, i interface{}) error {
+		return f(r, i.(*T))
+	}
+	validators := s.validators
+	s.validateFunc = reflect.ValueOf(func(r *RequestInfo, i interface{}) error {
+		if validate, ok := validators[reflect.TypeOf(i)]; ok {
+			return validate(r, i)
+		}
+		return nil
+	})
+}
+
+// Method registers fn as a single method of the server, without a receiver
+// type. The name uses a dotted notation as in "Service.Method".
+//
+// The method signature is checked at compile time. Methods registered this
+// way can share a service name with each other, but not with a service
+// registered with Server.RegisterService.
+func Method[Args, Reply any](s *Server, name string, fn func(*http.Request, *Args, *Reply) error) error {
+	call := func(_ interface{}, r *http.Request, args *Args, reply *Reply) error {
+		return fn(r, args, reply)
+	}
+	return s.services.registerFunc(name, reflect.ValueOf(call),
+		reflect.TypeOf((*Args)(nil)).Elem(),
+		reflect.TypeOf((*Reply)(nil)).Elem())
+}
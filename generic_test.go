@@ -0,0 +1,114 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/shridarpatil/rpc"
+)
+
+func TestValidate(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(ListService), ""); err != nil {
+		t.Fatal(err)
+	}
+	var validated []string
+	rpc.Validate(s, func(i *rpc.RequestInfo, args *HelloArgs) error {
+		validated = append(validated, i.Method)
+		if args.Who == "" {
+			return errors.New("who is required")
+		}
+		return nil
+	})
+	expectResult(t, call(s, "HelloService.Say", []HelloArgs{{Who: "a"}}), `{"Message":"Hello, a!"}`)
+	expectError(t, call(s, "HelloService.Say", []HelloArgs{{}}), http.StatusBadRequest, "who is required")
+	// Methods with other args types are not validated.
+	expect(t, call(s, "ListService.List", []ListArgs{{}}), http.StatusOK)
+	if len(validated) != 2 {
+		t.Errorf("validated methods = %v, want the two HelloService.Say calls", validated)
+	}
+}
+
+func TestValidateTypes(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(ListService), ""); err != nil {
+		t.Fatal(err)
+	}
+	rpc.Validate(s, func(i *rpc.RequestInfo, args *HelloArgs) error {
+		if args.Who == "" {
+			return errors.New("who is required")
+		}
+		return nil
+	})
+	rpc.Validate(s, func(i *rpc.RequestInfo, args *ListArgs) error {
+		if args.Cursor == "bad" {
+			return errors.New("invalid cursor")
+		}
+		return nil
+	})
+	// Both functions apply, each to its args type.
+	expectError(t, call(s, "HelloService.Say", []HelloArgs{{}}), http.StatusBadRequest, "who is required")
+	expectError(t, call(s, "ListService.List", []ListArgs{{Cursor: "bad"}}), http.StatusBadRequest, "invalid cursor")
+	expect(t, call(s, "ListService.List", []ListArgs{{}}), http.StatusOK)
+
+	// A later function for the same type overwrites the previous one.
+	rpc.Validate(s, func(i *rpc.RequestInfo, args *HelloArgs) error {
+		return nil
+	})
+	expect(t, call(s, "HelloService.Say", []HelloArgs{{}}), http.StatusOK)
+	expectError(t, call(s, "ListService.List", []ListArgs{{Cursor: "bad"}}), http.StatusBadRequest, "invalid cursor")
+}
+
+type SumArgs struct {
+	Values []int
+}
+
+type SumReply struct {
+	Sum int
+}
+
+func TestMethod(t *testing.T) {
+	s := newServer(t)
+	err := rpc.Method(s, "Math.Sum", func(r *http.Request, args *SumArgs, reply *SumReply) error {
+		for _, v := range args.Values {
+			reply.Sum += v
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rpc.Method(s, "Math.Fail", func(r *http.Request, args *SumArgs, reply *SumReply) error {
+		return errors.New("failed")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.HasMethod("Math.Sum") {
+		t.Error("Math.Sum not registered")
+	}
+	expectResult(t, call(s, "Math.Sum", []SumArgs{{Values: []int{1, 2, 3}}}), `{"Sum":6}`)
+	expectError(t, call(s, "Math.Fail", []SumArgs{{}}), http.StatusBadRequest, "failed")
+}
+
+func TestMethodInvalidName(t *testing.T) {
+	s := newServer(t)
+	fn := func(r *http.Request, args *SumArgs, reply *SumReply) error { return nil }
+	for _, name := range []string{"Sum", "HelloService.Sum", "rpc.Sum"} {
+		if err := rpc.Method(s, name, fn); err == nil {
+			t.Errorf("Method(%q) succeeded, want an error", name)
+		}
+	}
+	if err := rpc.Method(s, "Math.Sum", fn); err != nil {
+		t.Fatal(err)
+	}
+	if err := rpc.Method(s, "Math.Sum", fn); err == nil {
+		t.Error("duplicate method registered")
+	}
+}
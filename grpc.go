@@ -0,0 +1,89 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// GRPCCode is a gRPC status code, for services migrating from gRPC.
+type GRPCCode int
+
+// The gRPC status codes, with the same values as in gRPC.
+const (
+	GRPCOK GRPCCode = iota
+	GRPCCanceled
+	GRPCUnknown
+	GRPCInvalidArgument
+	GRPCDeadlineExceeded
+	GRPCNotFound
+	GRPCAlreadyExists
+	GRPCPermissionDenied
+	GRPCResourceExhausted
+	GRPCFailedPrecondition
+	GRPCAborted
+	GRPCOutOfRange
+	GRPCUnimplemented
+	GRPCInternal
+	GRPCUnavailable
+	GRPCDataLoss
+	GRPCUnauthenticated
+)
+
+// grpcCodes holds the name and the HTTP status of each gRPC status code,
+// following the conventional gRPC to HTTP mapping.
+var grpcCodes = [...]struct {
+	name   string
+	status int
+}{
+	GRPCOK:                 {"OK", http.StatusOK},
+	GRPCCanceled:           {"Canceled", StatusClientClosedRequest},
+	GRPCUnknown:            {"Unknown", http.StatusInternalServerError},
+	GRPCInvalidArgument:    {"InvalidArgument", http.StatusBadRequest},
+	GRPCDeadlineExceeded:   {"DeadlineExceeded", http.StatusGatewayTimeout},
+	GRPCNotFound:           {"NotFound", http.StatusNotFound},
+	GRPCAlreadyExists:      {"AlreadyExists", http.StatusConflict},
+	GRPCPermissionDenied:   {"PermissionDenied", http.StatusForbidden},
+	GRPCResourceExhausted:  {"ResourceExhausted", http.StatusTooManyRequests},
+	GRPCFailedPrecondition: {"FailedPrecondition", http.StatusBadRequest},
+	GRPCAborted:            {"Aborted", http.StatusConflict},
+	GRPCOutOfRange:         {"OutOfRange", http.StatusBadRequest},
+	GRPCUnimplemented:      {"Unimplemented", http.StatusNotImplemented},
+	GRPCInternal:           {"Internal", http.StatusInternalServerError},
+	GRPCUnavailable:        {"Unavailable", http.StatusServiceUnavailable},
+	GRPCDataLoss:           {"DataLoss", http.StatusInternalServerError},
+	GRPCUnauthenticated:    {"Unauthenticated", http.StatusUnauthorized},
+}
+
+// String returns the name of the code, as in "NotFound".
+func (c GRPCCode) String() string {
+	if c < 0 || int(c) >= len(grpcCodes) {
+		return "Code(" + strconv.Itoa(int(c)) + ")"
+	}
+	return grpcCodes[c].name
+}
+
+// HTTPStatus returns the HTTP status conventionally used for the code.
+// Unknown codes map to http.StatusInternalServerError.
+func (c GRPCCode) HTTPStatus() int {
+	if c < 0 || int(c) >= len(grpcCodes) {
+		return http.StatusInternalServerError
+	}
+	return grpcCodes[c].status
+}
+
+// GRPCStatusError is an error carrying a gRPC status code. When a method
+// returns it, possibly wrapped, the server replies with the HTTP status
+// mapped to the code, and codecs include the code name in the error.
+type GRPCStatusError struct {
+	Code    GRPCCode
+	Message string
+}
+
+func (e *GRPCStatusError) Error() string {
+	return e.Message
+}
@@ -0,0 +1,56 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/shridarpatil/rpc"
+)
+
+func TestGRPCCode(t *testing.T) {
+	tests := []struct {
+		code   rpc.GRPCCode
+		name   string
+		status int
+	}{
+		{rpc.GRPCOK, "OK", http.StatusOK},
+		{rpc.GRPCCanceled, "Canceled", rpc.StatusClientClosedRequest},
+		{rpc.GRPCNotFound, "NotFound", http.StatusNotFound},
+		{rpc.GRPCResourceExhausted, "ResourceExhausted", http.StatusTooManyRequests},
+		{rpc.GRPCUnauthenticated, "Unauthenticated", http.StatusUnauthorized},
+		{rpc.GRPCCode(42), "Code(42)", http.StatusInternalServerError},
+		{rpc.GRPCCode(-1), "Code(-1)", http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		if name := test.code.String(); name != test.name {
+			t.Errorf("%d: name = %q, want %q", int(test.code), name, test.name)
+		}
+		if status := test.code.HTTPStatus(); status != test.status {
+			t.Errorf("%d: status = %d, want %d", int(test.code), status, test.status)
+		}
+	}
+}
+
+type UserLookupService struct{}
+
+func (s *UserLookupService) Find(r *http.Request, args *HelloArgs, reply *HelloReply) error {
+	return fmt.Errorf("find %s: %w", args.Who, &rpc.GRPCStatusError{Code: rpc.GRPCNotFound, Message: "no such user"})
+}
+
+func TestGRPCStatusError(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(UserLookupService), ""); err != nil {
+		t.Fatal(err)
+	}
+	w := call(s, "UserLookupService.Find", []HelloArgs{{Who: "a"}})
+	expect(t, w, http.StatusNotFound)
+	if res := decode(t, w); !jsonEqual(res.Error, `{"message":"find a: no such user","code":"NotFound"}`) {
+		t.Errorf("error = %s", res.Error)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+)
+
+// sign returns the hex-encoded HMAC-SHA256 of a request, given its
+// canonical query.
+func sign(secret []byte, httpMethod, path, query, body string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(httpMethod + "\n" + path + "\n" + query + "\n" + body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestHMACVerification(t *testing.T) {
+	secret := []byte("secret")
+	s := newServer(t)
+	s.EnableHMACVerification(secret, "X-Signature")
+	body := `{"method":"HelloService.Say","params":[{"Who":"a"}]}`
+
+	tests := []struct {
+		signature string
+		status    int
+	}{
+		{sign(secret, "POST", "/rpc", "", body), http.StatusOK},
+		{"", http.StatusUnauthorized},
+		{"not hex", http.StatusUnauthorized},
+		{sign([]byte("other"), "POST", "/rpc", "", body), http.StatusUnauthorized},
+		{sign(secret, "POST", "/rpc", "", body+" "), http.StatusUnauthorized},
+		{sign(secret, "PUT", "/rpc", "", body), http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		r := serveRequest("POST", "/rpc", body)
+		if test.signature != "" {
+			r.Header.Set("X-Signature", test.signature)
+		}
+		w := serveHTTP(s, r)
+		if test.status == http.StatusOK {
+			expectResult(t, w, `{"Message":"Hello, a!"}`)
+		} else {
+			expectError(t, w, test.status, "invalid request signature")
+		}
+	}
+}
+
+func TestHMACVerificationURL(t *testing.T) {
+	secret := []byte("secret")
+	s := newServer(t)
+	s.EnableHMACVerification(secret, "X-Signature")
+	s.SetQueryParamMethods("GET")
+	s.MountService("HelloService", "/hello/")
+	signature := sign(secret, "GET", "/rpc", "Who=a&method=HelloService.Say", "")
+
+	tests := []struct {
+		httpMethod string
+		url        string
+		signature  string
+		status     int
+	}{
+		{"GET", "/rpc?method=HelloService.Say&Who=a", signature, http.StatusOK},
+		// The query is signed in canonical order.
+		{"GET", "/rpc?Who=a&method=HelloService.Say", signature, http.StatusOK},
+		{"GET", "/rpc?method=HelloService.Say&Who=b", signature, http.StatusUnauthorized},
+		{"GET", "/rpc?method=HelloService.Say&Who=a&Who=b", signature, http.StatusUnauthorized},
+		{"GET", "/rpc?method=HelloService.Say", signature, http.StatusUnauthorized},
+		// So is the path routing the method.
+		{"POST", "/hello/say", sign(secret, "POST", "/hello/say", "", ""), http.StatusOK},
+		{"POST", "/hello/say", sign(secret, "POST", "/rpc", "", ""), http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		r := serveRequest(test.httpMethod, test.url, "")
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Signature", test.signature)
+		w := serveHTTP(s, r)
+		if w.Code != test.status {
+			t.Errorf("%s %s: status = %d, want %d; body: %s", test.httpMethod, test.url, w.Code, test.status, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+)
+
+// InvokeFunc invokes a method with the decoded args, filling the reply. The
+// args and reply are pointers to the method args and reply types; args is
+// nil for methods without args.
+type InvokeFunc func(ctx context.Context, args, reply interface{}) error
+
+// UseInvocation adds a middleware wrapping the invocation of every method,
+// e.g. to retry calls or to break circuits. The middleware receives the
+// next InvokeFunc of the chain and returns the InvokeFunc to call instead.
+// The context it passes on becomes the context of the request given to the
+// method.
+//
+// Middlewares are called in the order they are added: the first one added
+// is the outermost.
+func (s *Server) UseInvocation(middleware func(next InvokeFunc) InvokeFunc) {
+	s.invokers = append(s.invokers, middleware)
+}
+
+// invokeChain calls a method through the invocation middlewares. call calls
+// the method with the given ins, whose second one is the request, or its
+// context if contextArg is true.
+func (s *Server) invokeChain(r *http.Request, in []reflect.Value, contextArg bool, args, reply interface{}, call func([]reflect.Value) []reflect.Value) []reflect.Value {
+	invoke := InvokeFunc(func(ctx context.Context, _, _ interface{}) error {
+		in := append([]reflect.Value(nil), in...)
+		if contextArg {
+			in[1] = reflect.ValueOf(ctx)
+		} else {
+			in[1] = reflect.ValueOf(r.WithContext(ctx))
+		}
+		err, _ := call(in)[0].Interface().(error)
+		return err
+	})
+	for i := len(s.invokers) - 1; i >= 0; i-- {
+		invoke = s.invokers[i](invoke)
+	}
+	err := invoke(r.Context(), args, reply)
+	return []reflect.Value{reflect.ValueOf(&err).Elem()}
+}
@@ -0,0 +1,160 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/shridarpatil/rpc"
+)
+
+// ----------------------------------------------------------------------------
+// Invocation context
+// ----------------------------------------------------------------------------
+
+// Store is a per-request value injected into methods.
+type Store struct {
+	Tenant string
+}
+
+type StoreService struct{}
+
+func (s *StoreService) Tenant(r *http.Request, args *HelloArgs, reply *HelloReply, store *Store) error {
+	if store == nil {
+		reply.Message = "no store"
+		return nil
+	}
+	reply.Message = store.Tenant
+	return nil
+}
+
+func TestInvocationContext(t *testing.T) {
+	s := newServer(t)
+	var calls int
+	s.SetInvocationContext(func(r *http.Request) interface{} {
+		calls++
+		if tenant := r.Header.Get("X-Tenant"); tenant != "" {
+			return &Store{Tenant: tenant}
+		}
+		return nil
+	})
+	if err := s.RegisterService(new(StoreService), ""); err != nil {
+		t.Fatal(err)
+	}
+	r := serveRequest("POST", "/rpc", `{"method":"StoreService.Tenant","params":[{}]}`)
+	r.Header.Set("X-Tenant", "acme")
+	expectResult(t, serveHTTP(s, r), `{"Message":"acme"}`)
+	// A nil value injects the zero value.
+	expectResult(t, call(s, "StoreService.Tenant", []HelloArgs{{}}), `{"Message":"no store"}`)
+	// Methods without the parameter don't call the function.
+	call(s, "HelloService.Say", []HelloArgs{{}})
+	if calls != 2 {
+		t.Errorf("calls = %d, want 2", calls)
+	}
+}
+
+func TestInvocationContextNotAssignable(t *testing.T) {
+	s := newServer(t)
+	s.SetInvocationContext(func(r *http.Request) interface{} {
+		return "not a store"
+	})
+	if err := s.RegisterService(new(StoreService), ""); err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, call(s, "StoreService.Tenant", []HelloArgs{{}}), http.StatusInternalServerError, "not assignable")
+}
+
+func TestInvocationContextRequired(t *testing.T) {
+	s := newServer(t)
+	// Without the function, the methods with an injected parameter are
+	// skipped: StoreService has no other method.
+	if err := s.RegisterService(new(StoreService), ""); err == nil {
+		t.Fatal("service registered without methods")
+	}
+	err := s.RegisterFuncMap("Funcs", map[string]interface{}{
+		"Tenant": func(r *http.Request, args *HelloArgs, reply *HelloReply, store *Store) error { return nil },
+	})
+	if err == nil {
+		t.Error("function with an injected parameter registered")
+	}
+
+	s.SetInvocationContext(func(r *http.Request) interface{} {
+		return &Store{Tenant: "acme"}
+	})
+	if err := s.RegisterService(new(StoreService), ""); err != nil {
+		t.Fatal(err)
+	}
+	expectResult(t, call(s, "StoreService.Tenant", []HelloArgs{{}}), `{"Message":"acme"}`)
+}
+
+// ----------------------------------------------------------------------------
+// Invocation middlewares
+// ----------------------------------------------------------------------------
+
+type FlakyService struct {
+	calls int
+}
+
+func (s *FlakyService) Do(r *http.Request, args *HelloArgs, reply *HelloReply) error {
+	s.calls++
+	if s.calls < 3 {
+		return errors.New("transient")
+	}
+	reply.Message, _ = r.Context().Value(ctxKey{}).(string)
+	return nil
+}
+
+func TestUseInvocation(t *testing.T) {
+	s := newServer(t)
+	flaky := new(FlakyService)
+	if err := s.RegisterService(flaky, ""); err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	s.UseInvocation(func(next rpc.InvokeFunc) rpc.InvokeFunc {
+		return func(ctx context.Context, args, reply interface{}) error {
+			order = append(order, "outer")
+			if _, ok := args.(*HelloArgs); !ok {
+				t.Errorf("args = %T", args)
+			}
+			return next(context.WithValue(ctx, ctxKey{}, "from middleware"), args, reply)
+		}
+	})
+	s.UseInvocation(func(next rpc.InvokeFunc) rpc.InvokeFunc {
+		return func(ctx context.Context, args, reply interface{}) error {
+			// Retry failed calls.
+			var err error
+			for i := 0; i < 3; i++ {
+				order = append(order, "retry")
+				if err = next(ctx, args, reply); err == nil {
+					break
+				}
+			}
+			return err
+		}
+	})
+	expectResult(t, call(s, "FlakyService.Do", []HelloArgs{{}}), `{"Message":"from middleware"}`)
+	if want := []string{"outer", "retry", "retry", "retry"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+	if flaky.calls != 3 {
+		t.Errorf("calls = %d, want 3", flaky.calls)
+	}
+}
+
+func TestUseInvocationShortCircuit(t *testing.T) {
+	s := newServer(t)
+	s.UseInvocation(func(next rpc.InvokeFunc) rpc.InvokeFunc {
+		return func(ctx context.Context, args, reply interface{}) error {
+			return errors.New("circuit open")
+		}
+	})
+	expectError(t, call(s, "HelloService.Say", []HelloArgs{{}}), http.StatusBadRequest, "circuit open")
+}
@@ -6,19 +6,29 @@
 package json
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/shridarpatil/rpc"
 )
 
 var null = json.RawMessage([]byte("null"))
 
+var typeOfRawMessage = reflect.TypeOf(json.RawMessage(nil))
+
 // An Error is a wrapper for a JSON interface value. It can be used by either
 // a service's handler func to write more complex JSON data to an error field
-// of a server's response, or by a client to read it.
+// of a server's response, or by a client to read it. Args types can also
+// return it, possibly wrapped, from their UnmarshalJSON method to reject
+// params with structured data.
 type Error struct {
 	Data interface{}
 }
@@ -31,45 +41,295 @@ func (e *Error) Error() string {
 // Request and Response
 // ----------------------------------------------------------------------------
 
+// version is the JSON-RPC version of the responses.
+const version = "2.0"
+
 // serverRequest represents a JSON-RPC request received by the server.
 type serverRequest struct {
+	// The JSON-RPC version, "2.0" if set. It is optional.
+	Version string `json:"jsonrpc"`
 	// A String containing the name of the method to be invoked.
 	Method string `json:"method"`
 	// An Array of objects to pass as arguments to the method.
 	Params *json.RawMessage `json:"params"`
 	// The request id. This can be of any type. It is used to match the
-	// response with the request that it is replying to.
-	// Id *json.RawMessage `json:"id"`
+	// response with the request that it is replying to. It is empty for
+	// notifications, which send no id.
+	Id json.RawMessage `json:"id"`
 }
 
 // serverResponse represents a JSON-RPC response returned by the server.
 type serverResponse struct {
+	// The JSON-RPC version, always "2.0".
+	Version string `json:"jsonrpc"`
 	// The Object that was returned by the invoked method. This must be null
 	// in case there was an error invoking the method.
 	Result interface{} `json:"result"`
 	// An Error object if there was an error invoking the method. It must be
 	// null if there was no error.
 	Error interface{} `json:"error"`
-	// This must be the same id as the request it is responding to.
-	// Id *json.RawMessage `json:"id"`
+	// Warnings added by the method with rpc.AddWarning, if any.
+	Warnings []string `json:"warnings,omitempty"`
+	// This must be the same id as the request it is responding to. It is
+	// omitted when the request has no id.
+	Id json.RawMessage `json:"id,omitempty"`
+}
+
+// notFoundError is the error object written for rpc.MethodNotFoundError.
+type notFoundError struct {
+	// Either "service not found" or "method not found".
+	Message string `json:"message"`
+	// The requested service.
+	Service string `json:"service"`
+	// The requested method, without the service.
+	Method string `json:"method"`
+}
+
+// retryableError is the error object written for rpc.RetryableError.
+type retryableError struct {
+	// The error message.
+	Message string `json:"message"`
+	// Whether the call can be retried.
+	Retryable bool `json:"retryable"`
+}
+
+// codedError is an error object with a JSON-RPC error code.
+type codedError struct {
+	// The JSON-RPC error code.
+	Code int `json:"code"`
+	// The error message.
+	Message string `json:"message"`
+}
+
+// grpcStatusError is the error object used for rpc.GRPCStatusError errors.
+type grpcStatusError struct {
+	// The error message.
+	Message string `json:"message"`
+	// The name of the gRPC status code, as in "NotFound".
+	Code string `json:"code"`
+}
+
+// paginatedResult is the result envelope used for rpc.Paginated replies.
+type paginatedResult struct {
+	// The items of the current page.
+	Items interface{} `json:"items"`
+	// The cursor of the next page, empty on the last page.
+	NextCursor string `json:"next_cursor"`
+}
+
+// multiStatusResult is the result envelope used for rpc.MultiStatus replies.
+type multiStatusResult struct {
+	// The results of the items that succeeded.
+	Succeeded interface{} `json:"succeeded"`
+	// The errors of the items that failed.
+	Failed []itemError `json:"failed"`
+}
+
+// itemError is the error of one failed item of a rpc.MultiStatus reply.
+type itemError struct {
+	// The position of the item in the request.
+	Index int `json:"index"`
+	// The error of the item.
+	Error interface{} `json:"error"`
 }
 
 // ----------------------------------------------------------------------------
 // Codec
 // ----------------------------------------------------------------------------
 
-// NewCodec returns a new JSON Codec.
+// NewCodec returns a new JSON Codec. Its options are set with the Set
+// methods, which can be combined.
 func NewCodec() *Codec {
 	return &Codec{}
 }
 
+// NewCodecDisallowUnknown returns a new JSON Codec rejecting params that
+// contain fields unknown to the method args. See SetDisallowUnknownFields.
+func NewCodecDisallowUnknown() *Codec {
+	c := NewCodec()
+	c.SetDisallowUnknownFields(true)
+	return c
+}
+
+// NewCodecWithMethodKeys returns a new JSON Codec reading the method name
+// from the first of the given request members present. See SetMethodKeys.
+func NewCodecWithMethodKeys(keys ...string) *Codec {
+	c := NewCodec()
+	c.SetMethodKeys(keys...)
+	return c
+}
+
+// NewCodecWithEnvelope returns a new JSON Codec writing responses in a
+// custom envelope. See SetEnvelope.
+func NewCodecWithEnvelope(successFn func(reply interface{}) interface{}, errorFn func(err error) interface{}) *Codec {
+	c := NewCodec()
+	c.SetEnvelope(successFn, errorFn)
+	return c
+}
+
+// NewCodecNoEnvelope returns a new JSON Codec writing replies at the top
+// level of responses. See SetNoEnvelope.
+func NewCodecNoEnvelope() *Codec {
+	c := NewCodec()
+	c.SetNoEnvelope()
+	return c
+}
+
+// NewCodecIndented returns a new JSON Codec writing indented responses. See
+// SetIndent.
+func NewCodecIndented(prefix, indent string) *Codec {
+	c := NewCodec()
+	c.SetIndent(prefix, indent)
+	return c
+}
+
+// NewCodecWithFieldNames returns a new JSON Codec naming the result and error
+// members of responses after resultField and errorField. See SetFieldNames.
+func NewCodecWithFieldNames(resultField, errorField string) *Codec {
+	c := NewCodec()
+	c.SetFieldNames(resultField, errorField)
+	return c
+}
+
 // Codec creates a CodecRequest to process each request.
 type Codec struct {
+	disallowUnknownFields bool
+	emptyResults          bool
+	methodKeys            []string
+	successEnvelope       func(reply interface{}) interface{}
+	errorEnvelope         func(err error) interface{}
+	indented              bool
+	prefix                string
+	indent                string
+	prettyHeader          bool
+	resultField           string
+	errorField            string
+	strictVersion         bool
+}
+
+// response returns the value written for a response, with the result and
+// error members renamed if the codec has custom field names.
+func (c *Codec) response(res *serverResponse) interface{} {
+	if c.resultField == "" && c.errorField == "" {
+		return res
+	}
+	resultField, errorField := c.resultField, c.errorField
+	if resultField == "" {
+		resultField = "result"
+	}
+	if errorField == "" {
+		errorField = "error"
+	}
+	renamed := map[string]interface{}{
+		"jsonrpc":   res.Version,
+		resultField: res.Result,
+		errorField:  res.Error,
+	}
+	if len(res.Warnings) > 0 {
+		renamed["warnings"] = res.Warnings
+	}
+	if len(res.Id) > 0 {
+		renamed["id"] = res.Id
+	}
+	return renamed
+}
+
+// SetDisallowUnknownFields controls whether params that contain fields
+// unknown to the method args are rejected. It is disabled by default.
+func (c *Codec) SetDisallowUnknownFields(enabled bool) {
+	c.disallowUnknownFields = enabled
+}
+
+// SetMethodKeys sets the request members the method name is read from, the
+// first one present winning, e.g. "method", "fn" or "action", to accept
+// heterogeneous clients. It defaults to "method".
+func (c *Codec) SetMethodKeys(keys ...string) {
+	c.methodKeys = keys
+}
+
+// SetEnvelope sets a custom envelope to write responses in, instead of the
+// {"result": ..., "error": ...} object. successFn maps a reply, and errorFn
+// an error, to the value written as the response body. Either function can
+// be nil to keep the default envelope.
+func (c *Codec) SetEnvelope(successFn func(reply interface{}) interface{}, errorFn func(err error) interface{}) {
+	c.successEnvelope, c.errorEnvelope = successFn, errorFn
+}
+
+// SetNoEnvelope sets the codec to write replies at the top level of
+// responses, as plain REST APIs do, with the HTTP status telling successes
+// from failures. Errors are written as a top-level object, where plain
+// error messages go in a "message" member. It replaces the envelope set
+// with SetEnvelope.
+func (c *Codec) SetNoEnvelope() {
+	c.SetEnvelope(
+		func(reply interface{}) interface{} {
+			return reply
+		},
+		func(err error) interface{} {
+			if msg, ok := errorObject(err).(string); ok {
+				return map[string]string{"message": msg}
+			}
+			return errorObject(err)
+		},
+	)
+}
+
+// SetIndent sets the codec to write indented responses, as with
+// json.MarshalIndent, for debugging.
+func (c *Codec) SetIndent(prefix, indent string) {
+	c.indented, c.prefix, c.indent = true, prefix, indent
+}
+
+// SetFieldNames names the result and error members of responses after
+// resultField and errorField, e.g. "data" and "errors", instead of
+// "result" and "error". An empty name keeps the default one.
+func (c *Codec) SetFieldNames(resultField, errorField string) {
+	c.resultField, c.errorField = resultField, errorField
+}
+
+// SetEmptyResults controls how nil replies are serialized. When enabled,
+// a reply that is a nil pointer, slice or map is written as an empty value
+// of its type, e.g. {} or [], instead of null. It is disabled by default.
+func (c *Codec) SetEmptyResults(enabled bool) {
+	c.emptyResults = enabled
+}
+
+// SetPrettyHeader controls whether clients can request indented responses
+// per request, by sending the "X-RPC-Pretty: 1" header. Responses are then
+// indented with two spaces. It is disabled by default.
+func (c *Codec) SetPrettyHeader(enabled bool) {
+	c.prettyHeader = enabled
+}
+
+// SetStrictVersion controls whether requests with a "jsonrpc" member other
+// than "2.0" are rejected with the JSON-RPC "Invalid Request" error, code
+// -32600. Requests without the member are always accepted. It is disabled
+// by default.
+func (c *Codec) SetStrictVersion(enabled bool) {
+	c.strictVersion = enabled
 }
 
 // NewRequest returns a CodecRequest.
 func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
-	return newCodecRequest(r)
+	return newCodecRequest(r, c)
+}
+
+// NewGETRequest returns a CodecRequest for a GET request.
+//
+// The method is read from the "method" query parameter and the remaining
+// query parameters, along with the path params (see rpc.PathParams), are
+// the fields of the params object. As URL values are strings, they are
+// converted to the types of the args fields they decode into: numbers and
+// booleans are parsed, and repeated parameters fill slices.
+func (c *Codec) NewGETRequest(r *http.Request) rpc.CodecRequest {
+	return newGETCodecRequest(r, c)
+}
+
+// WriteError encodes an error raised before a request is decoded, such as
+// an unsupported Content-Type, and writes it using the given status.
+func (c *Codec) WriteError(w http.ResponseWriter, status int, err error) {
+	req := &CodecRequest{codec: c, ctx: context.Background(), request: new(serverRequest)}
+	req.WriteError(w, status, err)
 }
 
 // ----------------------------------------------------------------------------
@@ -77,79 +337,475 @@ func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
 // ----------------------------------------------------------------------------
 
 // newCodecRequest returns a new CodecRequest.
-func newCodecRequest(r *http.Request) rpc.CodecRequest {
+func newCodecRequest(r *http.Request, codec *Codec) rpc.CodecRequest {
 	// Decode the request body and check if RPC method is valid.
 	req := new(serverRequest)
-	err := json.NewDecoder(r.Body).Decode(req)
+	var err error
+	if len(codec.methodKeys) == 0 {
+		err = json.NewDecoder(r.Body).Decode(req)
+	} else {
+		err = decodeWithMethodKeys(r.Body, req, codec.methodKeys)
+	}
 	r.Body.Close()
-	return &CodecRequest{request: req, err: err}
+	if err == io.EOF {
+		// An empty body holds no request object: the method may still be
+		// routed from the URL path, with empty params.
+		err = nil
+	}
+	if err == nil && codec.strictVersion && req.Version != "" && req.Version != version {
+		err = &Error{Data: &codedError{Code: -32600, Message: "Invalid Request: unsupported jsonrpc version " + strconv.Quote(req.Version)}}
+	}
+	var urlValues map[string]interface{}
+	if pathParams := rpc.PathParams(r.Context()); len(pathParams) > 0 {
+		urlValues = make(map[string]interface{}, len(pathParams))
+		for name, value := range pathParams {
+			urlValues[name] = value
+		}
+	}
+	return &CodecRequest{codec: codec, ctx: r.Context(), request: req, urlValues: urlValues, err: err, pretty: isPretty(r, codec)}
+}
+
+// decodeWithMethodKeys decodes a request body, reading the method from the
+// first member present among the given keys.
+func decodeWithMethodKeys(body io.Reader, req *serverRequest, keys []string) error {
+	var raw json.RawMessage
+	if err := json.NewDecoder(body).Decode(&raw); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, req); err != nil {
+		return err
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &members); err != nil {
+		return err
+	}
+	req.Method = ""
+	for _, key := range keys {
+		if value, ok := members[key]; ok {
+			return json.Unmarshal(value, &req.Method)
+		}
+	}
+	return nil
+}
+
+// newGETCodecRequest returns a new CodecRequest built from the URL query.
+func newGETCodecRequest(r *http.Request, codec *Codec) rpc.CodecRequest {
+	query := r.URL.Query()
+	req := &serverRequest{Method: query.Get("method")}
+	query.Del("method")
+	urlValues := make(map[string]interface{}, len(query))
+	for key, values := range query {
+		if len(values) == 1 {
+			urlValues[key] = values[0]
+		} else {
+			urlValues[key] = values
+		}
+	}
+	for name, value := range rpc.PathParams(r.Context()) {
+		urlValues[name] = value
+	}
+	return &CodecRequest{codec: codec, ctx: r.Context(), request: req, urlValues: urlValues, pretty: isPretty(r, codec)}
+}
+
+// decodeURLValues decodes values read from the URL into args, converting
+// the strings to the types of the args fields: a parameter given once is a
+// string and a repeated one a slice of strings.
+func decodeURLValues(values map[string]interface{}, args interface{}, disallowUnknownFields bool) error {
+	t := reflect.TypeOf(args)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	params := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		params[key] = value
+		if t == nil || t.Kind() != reflect.Struct {
+			continue
+		}
+		if fieldType, ok := urlFieldType(t, key); ok {
+			params[key] = convertURLValue(value, fieldType)
+		}
+	}
+	b, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if disallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	return dec.Decode(args)
+}
+
+// urlFieldType returns the type of the field of the struct type t a params
+// member decodes into, matching names as encoding/json does. Fields decoded
+// from strings, with the ",string" option, are left out.
+func urlFieldType(t reflect.Type, key string) (reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		name := tag[0]
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if fieldType, ok := urlFieldType(embedded, key); ok {
+					return fieldType, true
+				}
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if !strings.EqualFold(name, key) {
+			continue
+		}
+		for _, option := range tag[1:] {
+			if option == "string" {
+				return nil, false
+			}
+		}
+		return field.Type, true
+	}
+	return nil, false
+}
+
+// convertURLValue converts a value read from the URL, a string or a slice
+// of strings, to a value decoding into the type t.
+func convertURLValue(value interface{}, t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	values, ok := value.([]string)
+	if !ok {
+		return convertURLString(value.(string), t)
+	}
+	if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+		return values
+	}
+	converted := make([]interface{}, len(values))
+	for i, v := range values {
+		converted[i] = convertURLString(v, t.Elem())
+	}
+	return converted
+}
+
+// convertURLString converts a string read from the URL to a value decoding
+// into the type t. Strings that can't be converted are left unchanged, for
+// the decoder to report.
+func convertURLString(s string, t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if _, err := strconv.ParseFloat(s, 64); err == nil && json.Valid([]byte(s)) {
+			return json.Number(s)
+		}
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() != reflect.Uint8 {
+			return []interface{}{convertURLString(s, t.Elem())}
+		}
+	}
+	return s
+}
+
+// isPretty returns true if the client requests an indented response.
+func isPretty(r *http.Request, codec *Codec) bool {
+	return codec.prettyHeader && r.Header.Get("X-RPC-Pretty") == "1"
 }
 
 // CodecRequest decodes and encodes a single request.
 type CodecRequest struct {
+	codec   *Codec
+	ctx     context.Context
 	request *serverRequest
-	err     error
+	// The params read from the URL query and path, decoded after the
+	// params of the body.
+	urlValues map[string]interface{}
+	err       error
+	// Whether streamed replies are being written.
+	streaming bool
+	// Whether the client requested an indented response.
+	pretty bool
 }
 
 // Method returns the RPC method for the current request.
 //
 // The method uses a dotted notation as in "Service.Method".
 func (c *CodecRequest) Method() (string, error) {
-	if c.err == nil {
-		return c.request.Method, nil
+	if c.err != nil {
+		return "", c.err
+	}
+	if c.request.Method == "" {
+		return "", errors.New("rpc: method name missing")
 	}
-	return "", c.err
+	return c.request.Method, nil
 }
 
 // ReadRequest fills the request object for the RPC method.
+//
+// The params are either an array holding the args object, or the args object
+// itself. Missing or null params leave the args zero-valued.
 func (c *CodecRequest) ReadRequest(args interface{}) error {
-	if c.err == nil {
-		if c.request.Params != nil {
+	if c.err == nil && c.request.Params != nil {
+		dec := json.NewDecoder(bytes.NewReader(*c.request.Params))
+		if c.codec.disallowUnknownFields {
+			dec.DisallowUnknownFields()
+		}
+		if isObject(*c.request.Params) || !wrapsArgs(*c.request.Params, args) {
+			c.err = dec.Decode(args)
+		} else {
 			// JSON params is array value. RPC params is struct.
 			// Unmarshal into array containing the request struct.
 			params := [1]interface{}{args}
-			c.err = json.Unmarshal(*c.request.Params, &params)
-		} else {
-			c.err = errors.New("rpc: method request ill-formed: missing params field")
+			c.err = dec.Decode(&params)
 		}
 	}
+	if c.err == nil && len(c.urlValues) > 0 {
+		c.err = decodeURLValues(c.urlValues, args, c.codec.disallowUnknownFields)
+	}
 	return c.err
 }
 
+// RawParams returns the params object as raw JSON values keyed by name,
+// including the values read from the URL. It returns nil if the request
+// params are missing or not an object.
+func (c *CodecRequest) RawParams() map[string]json.RawMessage {
+	if c.err != nil {
+		return nil
+	}
+	var params [1]map[string]json.RawMessage
+	if c.request.Params == nil {
+		// No params in the body.
+	} else if isObject(*c.request.Params) {
+		if err := json.Unmarshal(*c.request.Params, &params[0]); err != nil {
+			return nil
+		}
+	} else if err := json.Unmarshal(*c.request.Params, &params); err != nil {
+		return nil
+	}
+	for key, value := range c.urlValues {
+		if params[0] == nil {
+			params[0] = make(map[string]json.RawMessage, len(c.urlValues))
+		}
+		params[0][key], _ = json.Marshal(value)
+	}
+	return params[0]
+}
+
+// newMultiStatusResult builds the result envelope of a rpc.MultiStatus reply.
+func newMultiStatusResult(m rpc.MultiStatus) *multiStatusResult {
+	res := &multiStatusResult{Succeeded: m.Succeeded(), Failed: []itemError{}}
+	for _, item := range m.Failed() {
+		res.Failed = append(res.Failed, itemError{Index: item.Index, Error: errorObject(item.Err)})
+	}
+	return res
+}
+
+// wrapsArgs returns true if array params wrap the args in a single-element
+// array, as sent by positional JSON-RPC clients. Params are always wrapped
+// for struct args, while for slice or array args they are the args
+// themselves unless their only element is an array too.
+func wrapsArgs(raw json.RawMessage, args interface{}) bool {
+	t := reflect.TypeOf(args)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || (t.Kind() != reflect.Slice && t.Kind() != reflect.Array) {
+		return true
+	}
+	var elems []json.RawMessage
+	if err := json.Unmarshal(raw, &elems); err != nil || len(elems) != 1 {
+		return false
+	}
+	elem := bytes.TrimLeft(elems[0], " \t\r\n")
+	return len(elem) > 0 && elem[0] == '['
+}
+
+// isObject returns true if a JSON value is an object.
+func isObject(raw json.RawMessage) bool {
+	raw = bytes.TrimLeft(raw, " \t\r\n")
+	return len(raw) > 0 && raw[0] == '{'
+}
+
 // WriteResponse encodes the response and writes it to the ResponseWriter.
 func (c *CodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
-	// if c.request.Id != nil {
-	// 	// Id is null for notifications and they don't have a response.
-	// }
+	if p, ok := reply.(rpc.Paginated); ok {
+		reply = &paginatedResult{Items: p.Items(), NextCursor: p.NextCursor()}
+	} else if m, ok := reply.(rpc.MultiStatus); ok {
+		reply = newMultiStatusResult(m)
+	} else if c.codec.emptyResults {
+		reply = emptyResult(reply)
+	}
+	if fields := rpc.SelectedFields(c.ctx); fields != nil {
+		reply = selectFields(reply, fields)
+	}
+	if c.codec.successEnvelope != nil {
+		c.writeServerResponse(w, 200, c.codec.successEnvelope(reply))
+		return
+	}
 	res := &serverResponse{
-		Result: reply,
-		Error:  &null,
-		// Id:     c.request.Id,
+		Version:  version,
+		Result:   reply,
+		Error:    &null,
+		Warnings: rpc.Warnings(c.ctx),
+		Id:       c.request.Id,
 	}
 	c.writeServerResponse(w, 200, res)
 }
 
-func (c *CodecRequest) WriteError(w http.ResponseWriter, _ int, err error) {
+// WriteError encodes the error and writes it to the ResponseWriter using
+// the given HTTP status.
+func (c *CodecRequest) WriteError(w http.ResponseWriter, status int, err error) {
+	if c.codec.errorEnvelope != nil {
+		c.writeServerResponse(w, status, c.codec.errorEnvelope(err))
+		return
+	}
 	res := &serverResponse{
-		Result: &null,
-		// Id:     c.request.Id,
+		Version: version,
+		Result:  &null,
+		Error:   errorObject(err),
+		Id:      c.request.Id,
 	}
-	if jsonErr, ok := err.(*Error); ok {
-		res.Error = jsonErr.Data
-	} else {
-		res.Error = err.Error()
+	c.writeServerResponse(w, status, res)
+}
+
+// WriteStreamReply writes a reply of a streaming method as a single line of
+// newline-delimited JSON, holding a response object.
+func (c *CodecRequest) WriteStreamReply(w http.ResponseWriter, reply interface{}) error {
+	return c.writeStreamLine(w, &serverResponse{Version: version, Result: reply, Error: &null, Id: c.request.Id})
+}
+
+// WriteStreamError writes the error ending a stream as a last line holding
+// an error response object.
+func (c *CodecRequest) WriteStreamError(w http.ResponseWriter, err error) {
+	c.writeStreamLine(w, &serverResponse{Version: version, Result: &null, Error: errorObject(err), Id: c.request.Id})
+}
+
+func (c *CodecRequest) writeStreamLine(w http.ResponseWriter, res *serverResponse) error {
+	b, err := json.Marshal(c.codec.response(res))
+	if err != nil {
+		return err
+	}
+	if !c.streaming {
+		c.streaming = true
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(200)
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// errorObject returns the value written in the error member of a response.
+func errorObject(err error) interface{} {
+	var jsonErr *Error
+	if errors.As(err, &jsonErr) {
+		return jsonErr.Data
+	}
+	if nfErr, ok := err.(*rpc.MethodNotFoundError); ok {
+		e := &notFoundError{
+			Message: "service not found",
+			Service: nfErr.Service,
+			Method:  nfErr.Method,
+		}
+		if nfErr.ServiceFound {
+			e.Message = "method not found"
+		}
+		return e
+	}
+	var grpcErr *rpc.GRPCStatusError
+	if errors.As(err, &grpcErr) {
+		return &grpcStatusError{Message: err.Error(), Code: grpcErr.Code.String()}
+	}
+	var retryable rpc.RetryableError
+	if errors.As(err, &retryable) {
+		return &retryableError{Message: err.Error(), Retryable: retryable.Retryable()}
 	}
-	c.writeServerResponse(w, 400, res)
+	return err.Error()
 }
 
-func (c *CodecRequest) writeServerResponse(w http.ResponseWriter, status int, res *serverResponse) {
-	b, err := json.Marshal(res)
+// emptyResult returns the zero value of the reply type if the reply is a
+// nil pointer, slice or map, so that it is not serialized as null.
+// Otherwise the reply is returned unchanged, as are raw JSON replies: an
+// empty json.RawMessage is not valid JSON.
+func emptyResult(reply interface{}) interface{} {
+	v := reflect.ValueOf(reply)
+	for v.Kind() == reflect.Ptr {
+		if v.Type().Elem() == typeOfRawMessage {
+			return reply
+		}
+		if v.IsNil() {
+			return emptyResult(reflect.New(v.Type().Elem()).Interface())
+		}
+		v = v.Elem()
+	}
+	switch {
+	case v.Kind() == reflect.Slice && v.Type() == typeOfRawMessage:
+		return reply
+	case v.Kind() == reflect.Slice && v.IsNil():
+		return reflect.MakeSlice(v.Type(), 0, 0).Interface()
+	case v.Kind() == reflect.Map && v.IsNil():
+		return reflect.MakeMap(v.Type()).Interface()
+	}
+	return reply
+}
+
+// selectFields returns the given fields of a reply encoded as a JSON object.
+// Other replies are returned unchanged.
+func selectFields(reply interface{}, fields []string) interface{} {
+	b, err := json.Marshal(reply)
+	if err != nil {
+		return reply
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(b, &members); err != nil || members == nil {
+		return reply
+	}
+	selected := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		if value, ok := members[field]; ok {
+			selected[field] = value
+		}
+	}
+	return selected
+}
+
+func (c *CodecRequest) writeServerResponse(w http.ResponseWriter, status int, res interface{}) {
+	if r, ok := res.(*serverResponse); ok {
+		res = c.codec.response(r)
+	}
+	var b []byte
+	var err error
+	switch {
+	case c.codec.indented:
+		b, err = json.MarshalIndent(res, c.codec.prefix, c.codec.indent)
+	case c.pretty:
+		b, err = json.MarshalIndent(res, "", "  ")
+	default:
+		b, err = json.Marshal(res)
+	}
 	if err == nil {
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		w.WriteHeader(status)
 		w.Write(b)
 	} else {
-		// Not sure in which case will this happen. But seems harmless.
-		rpc.WriteError(w, 400, err.Error())
+		// The reply can't be encoded, e.g. it holds a channel: this is a
+		// server error.
+		rpc.WriteError(w, http.StatusInternalServerError, "rpc: failed to encode the response: "+err.Error())
 	}
-}
\ No newline at end of file
+}
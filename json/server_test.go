@@ -0,0 +1,445 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package json
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shridarpatil/rpc"
+)
+
+type Service1Request struct {
+	A int
+	B int
+}
+
+type Service1Response struct {
+	Result int
+}
+
+type Service1 struct{}
+
+func (t *Service1) Multiply(r *http.Request, req *Service1Request, res *Service1Response) error {
+	res.Result = req.A * req.B
+	return nil
+}
+
+// newServer returns a server serving Service1 with the given codec.
+func newServer(t *testing.T, codec *Codec) *rpc.Server {
+	t.Helper()
+	s := rpc.NewServer()
+	s.RegisterCodec(codec, "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// execute posts a JSON request body to the server.
+func execute(s http.Handler, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/rpc", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+// decodeBody decodes a JSON response body into a generic value.
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response %q is not JSON: %v", w.Body.String(), err)
+	}
+	return body
+}
+
+// expectBody checks the status and the decoded body of a response.
+func expectBody(t *testing.T, w *httptest.ResponseRecorder, status int, want string) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d; body: %s", w.Code, status, w.Body.String())
+	}
+	var wantBody map[string]interface{}
+	if err := json.Unmarshal([]byte(want), &wantBody); err != nil {
+		t.Fatal(err)
+	}
+	if got := decodeBody(t, w); !reflect.DeepEqual(got, wantBody) {
+		t.Fatalf("body = %s, want %s", w.Body.String(), want)
+	}
+}
+
+func TestService(t *testing.T) {
+	s := newServer(t, NewCodec())
+	w := execute(s, `{"method":"Service1.Multiply","params":[{"A":4,"B":2}],"id":1}`)
+	expectBody(t, w, http.StatusOK, `{"jsonrpc":"2.0","result":{"Result":8},"error":null,"id":1}`)
+}
+
+func TestDisallowUnknownFields(t *testing.T) {
+	s := newServer(t, NewCodecDisallowUnknown())
+	w := execute(s, `{"method":"Service1.Multiply","params":{"A":4,"B":2}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	w = execute(s, `{"method":"Service1.Multiply","params":{"A":4,"C":2}}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if !strings.Contains(w.Body.String(), `unknown field \"C\"`) {
+		t.Errorf("body %q does not name the unknown field", w.Body.String())
+	}
+
+	// The default codec ignores unknown fields.
+	s = newServer(t, NewCodec())
+	w = execute(s, `{"method":"Service1.Multiply","params":{"A":4,"C":2}}`)
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestMethodNotFound(t *testing.T) {
+	s := newServer(t, NewCodec())
+	w := execute(s, `{"method":"Service1.Divide","params":[{}],"id":1}`)
+	expectBody(t, w, http.StatusBadRequest, `{"jsonrpc":"2.0","result":null,"id":1,
+		"error":{"message":"method not found","service":"Service1","method":"Divide"}}`)
+
+	w = execute(s, `{"method":"Service2.Multiply","params":[{}],"id":1}`)
+	expectBody(t, w, http.StatusBadRequest, `{"jsonrpc":"2.0","result":null,"id":1,
+		"error":{"message":"service not found","service":"Service2","method":"Multiply"}}`)
+}
+
+type EmptyService struct{}
+
+func (s *EmptyService) Object(r *http.Request, args *struct{}, reply **Service1Response) error {
+	return nil
+}
+
+func (s *EmptyService) List(r *http.Request, args *struct{}, reply *[]string) error {
+	return nil
+}
+
+func (s *EmptyService) Map(r *http.Request, args *struct{}, reply *map[string]int) error {
+	return nil
+}
+
+func TestEmptyResults(t *testing.T) {
+	tests := []struct {
+		method string
+		null   string
+		empty  string
+	}{
+		{"EmptyService.Object", `null`, `{"Result":0}`},
+		{"EmptyService.List", `null`, `[]`},
+		{"EmptyService.Map", `null`, `{}`},
+	}
+	for _, enabled := range []bool{false, true} {
+		codec := NewCodec()
+		codec.SetEmptyResults(enabled)
+		s := newServer(t, codec)
+		if err := s.RegisterService(new(EmptyService), ""); err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range tests {
+			want := test.null
+			if enabled {
+				want = test.empty
+			}
+			w := execute(s, `{"method":"`+test.method+`","params":[{}]}`)
+			expectBody(t, w, http.StatusOK, `{"jsonrpc":"2.0","result":`+want+`,"error":null}`)
+		}
+	}
+}
+
+type RawService struct{}
+
+func (s *RawService) Cached(r *http.Request, args *struct{}, reply *json.RawMessage) error {
+	*reply = rpc.Raw([]byte(`{"cached":true}`))
+	return nil
+}
+
+func (s *RawService) Missing(r *http.Request, args *struct{}, reply *json.RawMessage) error {
+	return nil
+}
+
+func TestRawReply(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		codec := NewCodec()
+		codec.SetEmptyResults(enabled)
+		s := newServer(t, codec)
+		if err := s.RegisterService(new(RawService), ""); err != nil {
+			t.Fatal(err)
+		}
+		w := execute(s, `{"method":"RawService.Cached","params":[{}]}`)
+		expectBody(t, w, http.StatusOK, `{"jsonrpc":"2.0","result":{"cached":true},"error":null}`)
+		// A nil raw reply is written as null, even with empty results.
+		w = execute(s, `{"method":"RawService.Missing","params":[{}]}`)
+		expectBody(t, w, http.StatusOK, `{"jsonrpc":"2.0","result":null,"error":null}`)
+	}
+}
+
+type ArrayService struct{}
+
+func (s *ArrayService) Sum(r *http.Request, args *[]int, reply *int) error {
+	for _, n := range *args {
+		*reply += n
+	}
+	return nil
+}
+
+func TestArrayArgs(t *testing.T) {
+	s := newServer(t, NewCodec())
+	if err := s.RegisterService(new(ArrayService), ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, params := range []string{`[1,2,3]`, `[[1,2,3]]`, `[ [1,2,3] ]`} {
+		w := execute(s, `{"method":"ArrayService.Sum","params":`+params+`}`)
+		expectBody(t, w, http.StatusOK, `{"jsonrpc":"2.0","result":6,"error":null}`)
+	}
+	// A single element is not mistaken for wrapped args.
+	w := execute(s, `{"method":"ArrayService.Sum","params":[4]}`)
+	expectBody(t, w, http.StatusOK, `{"jsonrpc":"2.0","result":4,"error":null}`)
+}
+
+func TestMethodKeys(t *testing.T) {
+	s := newServer(t, NewCodecWithMethodKeys("fn", "action", "method"))
+	for _, body := range []string{
+		`{"fn":"Service1.Multiply","params":{"A":2,"B":3}}`,
+		`{"action":"Service1.Multiply","params":{"A":2,"B":3}}`,
+		`{"method":"Service1.Multiply","params":{"A":2,"B":3}}`,
+		// The first key present wins.
+		`{"method":"Service1.Unknown","fn":"Service1.Multiply","params":{"A":2,"B":3}}`,
+	} {
+		w := execute(s, body)
+		expectBody(t, w, http.StatusOK, `{"jsonrpc":"2.0","result":{"Result":6},"error":null}`)
+	}
+	w := execute(s, `{"call":"Service1.Multiply","params":{"A":2,"B":3}}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "method name missing") {
+		t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	codec := NewCodecWithEnvelope(
+		func(reply interface{}) interface{} {
+			return map[string]interface{}{"ok": true, "data": reply}
+		},
+		func(err error) interface{} {
+			return map[string]interface{}{"ok": false, "reason": err.Error()}
+		},
+	)
+	s := newServer(t, codec)
+	w := execute(s, `{"method":"Service1.Multiply","params":{"A":2,"B":3}}`)
+	expectBody(t, w, http.StatusOK, `{"ok":true,"data":{"Result":6}}`)
+	w = execute(s, `{"method":"Service1.Divide","params":{}}`)
+	expectBody(t, w, http.StatusBadRequest, `{"ok":false,"reason":"rpc: can't find method \"Service1.Divide\""}`)
+}
+
+func TestEnvelopePartial(t *testing.T) {
+	codec := NewCodecWithEnvelope(nil, func(err error) interface{} {
+		return map[string]interface{}{"reason": err.Error()}
+	})
+	s := newServer(t, codec)
+	w := execute(s, `{"method":"Service1.Multiply","params":{"A":2,"B":3}}`)
+	expectBody(t, w, http.StatusOK, `{"jsonrpc":"2.0","result":{"Result":6},"error":null}`)
+}
+
+func TestMissingParams(t *testing.T) {
+	s := newServer(t, NewCodec())
+	for _, body := range []string{
+		`{"method":"Service1.Multiply"}`,
+		`{"method":"Service1.Multiply","params":null}`,
+		`{"method":"Service1.Multiply","params":{}}`,
+		`{"method":"Service1.Multiply","params":[{}]}`,
+		`{"method":"Service1.Multiply","params":[null]}`,
+	} {
+		w := execute(s, body)
+		expectBody(t, w, http.StatusOK, `{"jsonrpc":"2.0","result":{"Result":0},"error":null}`)
+	}
+}
+
+func TestIndented(t *testing.T) {
+	s := newServer(t, NewCodecIndented("", "\t"))
+	w := execute(s, `{"method":"Service1.Multiply","params":{"A":2,"B":3},"id":1}`)
+	want := "{\n\t\"jsonrpc\": \"2.0\",\n\t\"result\": {\n\t\t\"Result\": 6\n\t},\n\t\"error\": null,\n\t\"id\": 1\n}"
+	if body := w.Body.String(); body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
+
+func TestPrettyHeader(t *testing.T) {
+	body := `{"method":"Service1.Multiply","params":{"A":2,"B":3}}`
+	pretty := "{\n  \"jsonrpc\": \"2.0\",\n  \"result\": {\n    \"Result\": 6\n  },\n  \"error\": null\n}"
+	compact := `{"jsonrpc":"2.0","result":{"Result":6},"error":null}`
+	for _, enabled := range []bool{false, true} {
+		codec := NewCodec()
+		codec.SetPrettyHeader(enabled)
+		s := newServer(t, codec)
+		r := httptest.NewRequest("POST", "/rpc", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-RPC-Pretty", "1")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		want := compact
+		if enabled {
+			want = pretty
+		}
+		if got := w.Body.String(); got != want {
+			t.Errorf("enabled %t: body = %q, want %q", enabled, got, want)
+		}
+		// Without the header, responses are compact.
+		if got := execute(s, body).Body.String(); got != compact {
+			t.Errorf("enabled %t: body = %q without the header", enabled, got)
+		}
+	}
+}
+
+func TestFieldNames(t *testing.T) {
+	s := newServer(t, NewCodecWithFieldNames("data", "errors"))
+	w := execute(s, `{"method":"Service1.Multiply","params":{"A":2,"B":3},"id":1}`)
+	expectBody(t, w, http.StatusOK, `{"jsonrpc":"2.0","data":{"Result":6},"errors":null,"id":1}`)
+	w = execute(s, `{"method":"Service1.Divide","params":{}}`)
+	expectBody(t, w, http.StatusBadRequest, `{"jsonrpc":"2.0","data":null,
+		"errors":{"message":"method not found","service":"Service1","method":"Divide"}}`)
+
+	// A field left empty keeps its default name.
+	s = newServer(t, NewCodecWithFieldNames("data", ""))
+	w = execute(s, `{"method":"Service1.Multiply","params":{"A":2,"B":3}}`)
+	expectBody(t, w, http.StatusOK, `{"jsonrpc":"2.0","data":{"Result":6},"error":null}`)
+}
+
+func TestCombinedOptions(t *testing.T) {
+	codec := NewCodec()
+	codec.SetDisallowUnknownFields(true)
+	codec.SetMethodKeys("fn")
+	codec.SetFieldNames("data", "errors")
+	codec.SetIndent("", " ")
+	s := newServer(t, codec)
+	w := execute(s, `{"fn":"Service1.Multiply","params":{"A":2,"B":3}}`)
+	want := "{\n \"data\": {\n  \"Result\": 6\n },\n \"errors\": null,\n \"jsonrpc\": \"2.0\"\n}"
+	if body := w.Body.String(); body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+	w = execute(s, `{"fn":"Service1.Multiply","params":{"A":2,"C":3}}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"errors": "json: unknown field`) {
+		t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	// Without an envelope, the field names are irrelevant.
+	codec.SetNoEnvelope()
+	w = execute(s, `{"fn":"Service1.Multiply","params":{"A":2,"B":3}}`)
+	if body := w.Body.String(); body != "{\n \"Result\": 6\n}" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+type CheckedArgs struct {
+	Age int
+}
+
+func (a *CheckedArgs) UnmarshalJSON(b []byte) error {
+	var raw struct{ Age int }
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if raw.Age < 0 {
+		return fmt.Errorf("invalid params: %w", &Error{Data: map[string]interface{}{"field": "Age", "reason": "negative"}})
+	}
+	a.Age = raw.Age
+	return nil
+}
+
+type CheckedService struct{}
+
+func (s *CheckedService) Check(r *http.Request, args *CheckedArgs, reply *int) error {
+	if args.Age > 150 {
+		return fmt.Errorf("check: %w", &Error{Data: map[string]interface{}{"field": "Age", "reason": "too large"}})
+	}
+	*reply = args.Age
+	return nil
+}
+
+func TestWrappedError(t *testing.T) {
+	s := newServer(t, NewCodec())
+	if err := s.RegisterService(new(CheckedService), ""); err != nil {
+		t.Fatal(err)
+	}
+	w := execute(s, `{"method":"CheckedService.Check","params":{"Age":30}}`)
+	expectBody(t, w, http.StatusOK, `{"jsonrpc":"2.0","result":30,"error":null}`)
+	// The data of errors returned from UnmarshalJSON is kept.
+	w = execute(s, `{"method":"CheckedService.Check","params":{"Age":-1}}`)
+	expectBody(t, w, http.StatusBadRequest, `{"jsonrpc":"2.0","result":null,"error":{"field":"Age","reason":"negative"}}`)
+	// As is the data of errors wrapped by methods.
+	w = execute(s, `{"method":"CheckedService.Check","params":{"Age":200}}`)
+	expectBody(t, w, http.StatusBadRequest, `{"jsonrpc":"2.0","result":null,"error":{"field":"Age","reason":"too large"}}`)
+}
+
+func TestNoEnvelope(t *testing.T) {
+	s := newServer(t, NewCodecNoEnvelope())
+	if err := s.RegisterService(new(CheckedService), ""); err != nil {
+		t.Fatal(err)
+	}
+	w := execute(s, `{"method":"Service1.Multiply","params":{"A":2,"B":3}}`)
+	expectBody(t, w, http.StatusOK, `{"Result":6}`)
+	w = execute(s, `{"method":"CheckedService.Check","params":{"Age":200}}`)
+	expectBody(t, w, http.StatusBadRequest, `{"field":"Age","reason":"too large"}`)
+	w = execute(s, `{"method":"Service1.Divide","params":{}}`)
+	expectBody(t, w, http.StatusBadRequest, `{"message":"method not found","service":"Service1","method":"Divide"}`)
+	// Plain error messages are wrapped in an object.
+	w = execute(s, `{"method":"Service1.Multiply","params":{"A":"x"}}`)
+	body := decodeBody(t, w)
+	if msg, _ := body["message"].(string); w.Code != http.StatusBadRequest || len(body) != 1 || !strings.Contains(msg, "cannot unmarshal") {
+		t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRequestId(t *testing.T) {
+	s := newServer(t, NewCodec())
+	for _, id := range []string{`1`, `"abc"`, `{"n":1}`, `null`} {
+		w := execute(s, `{"method":"Service1.Multiply","params":{"A":2,"B":3},"id":`+id+`}`)
+		expectBody(t, w, http.StatusOK, `{"jsonrpc":"2.0","result":{"Result":6},"error":null,"id":`+id+`}`)
+		// Error responses carry the id too.
+		var want interface{}
+		if err := json.Unmarshal([]byte(id), &want); err != nil {
+			t.Fatal(err)
+		}
+		w = execute(s, `{"method":"Service1.Multiply","params":{"A":"x"},"id":`+id+`}`)
+		if got, ok := decodeBody(t, w)["id"]; w.Code != http.StatusBadRequest || !ok || !reflect.DeepEqual(got, want) {
+			t.Errorf("status = %d, body = %s, want id %s", w.Code, w.Body.String(), id)
+		}
+	}
+	// Requests without an id get responses without one.
+	w := execute(s, `{"method":"Service1.Multiply","params":{"A":2,"B":3}}`)
+	if _, ok := decodeBody(t, w)["id"]; ok {
+		t.Errorf("body = %s, want no id", w.Body.String())
+	}
+}
+
+func TestStrictVersion(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		codec := NewCodec()
+		codec.SetStrictVersion(strict)
+		s := newServer(t, codec)
+		for _, body := range []string{
+			`{"jsonrpc":"2.0","method":"Service1.Multiply","params":{"A":2,"B":3},"id":1}`,
+			`{"method":"Service1.Multiply","params":{"A":2,"B":3},"id":1}`,
+		} {
+			expectBody(t, execute(s, body), http.StatusOK, `{"jsonrpc":"2.0","result":{"Result":6},"error":null,"id":1}`)
+		}
+		w := execute(s, `{"jsonrpc":"1.0","method":"Service1.Multiply","params":{"A":2,"B":3},"id":1}`)
+		if !strict {
+			expectBody(t, w, http.StatusOK, `{"jsonrpc":"2.0","result":{"Result":6},"error":null,"id":1}`)
+			continue
+		}
+		expectBody(t, w, http.StatusBadRequest, `{"jsonrpc":"2.0","result":null,"id":1,
+			"error":{"code":-32600,"message":"Invalid Request: unsupported jsonrpc version \"1.0\""}}`)
+	}
+}
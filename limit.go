@@ -0,0 +1,72 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrQueueTimeout is returned when a call to a method whose concurrency is
+// limited waits too long for a slot. The server replies with
+// http.StatusServiceUnavailable.
+var ErrQueueTimeout = errors.New("rpc: timeout waiting for the method to be available")
+
+// methodLimit bounds the concurrent invocations of a method.
+type methodLimit struct {
+	slots   chan struct{}
+	maxWait time.Duration
+}
+
+// SetMethodConcurrency limits the number of concurrent invocations of a
+// registered method, e.g. one backed by a small resource pool. Calls beyond
+// the limit wait for a slot, for at most maxWait or until the request
+// context is done, and fail with ErrQueueTimeout otherwise. A zero maxWait
+// only bounds the wait by the request context. The wait duration is reported
+// in RequestInfo.QueueWait.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (s *Server) SetMethodConcurrency(method string, limit int, maxWait time.Duration) error {
+	if limit <= 0 {
+		return fmt.Errorf("rpc: invalid concurrency limit %d for method %q", limit, method)
+	}
+	_, serviceMethod, err := s.services.get(method)
+	if err != nil {
+		return err
+	}
+	s.services.mutex.Lock()
+	defer s.services.mutex.Unlock()
+	serviceMethod.limit = &methodLimit{
+		slots:   make(chan struct{}, limit),
+		maxWait: maxWait,
+	}
+	return nil
+}
+
+// acquire waits for a slot and returns the function releasing it, along
+// with the time spent waiting. The wait is zero if a slot is free.
+func (l *methodLimit) acquire(ctx context.Context) (func(), time.Duration, error) {
+	release := func() { <-l.slots }
+	select {
+	case l.slots <- struct{}{}:
+		return release, 0, nil
+	default:
+	}
+	start := time.Now()
+	if l.maxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.maxWait)
+		defer cancel()
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return release, time.Since(start), nil
+	case <-ctx.Done():
+		return nil, time.Since(start), ErrQueueTimeout
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shridarpatil/rpc"
+)
+
+type GateService struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *GateService) Hold(r *http.Request, args *HelloArgs, reply *HelloReply) error {
+	s.entered <- struct{}{}
+	<-s.release
+	reply.Message = "released"
+	return nil
+}
+
+// newGateServer returns a server with GateService.Hold limited to one
+// concurrent call.
+func newGateServer(t *testing.T, maxWait time.Duration) (*rpc.Server, *GateService) {
+	t.Helper()
+	s := newServer(t)
+	gate := &GateService{entered: make(chan struct{}, 2), release: make(chan struct{})}
+	if err := s.RegisterService(gate, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetMethodConcurrency("GateService.Hold", 1, maxWait); err != nil {
+		t.Fatal(err)
+	}
+	return s, gate
+}
+
+// hold calls GateService.Hold in the background, once the method is
+// entered.
+func hold(t *testing.T, s *rpc.Server, gate *GateService) chan *httptest.ResponseRecorder {
+	t.Helper()
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		done <- call(s, "GateService.Hold", []HelloArgs{{}})
+	}()
+	<-gate.entered
+	return done
+}
+
+func TestMethodConcurrencyTimeout(t *testing.T) {
+	s, gate := newGateServer(t, 20*time.Millisecond)
+	first := hold(t, s, gate)
+
+	expectError(t, call(s, "GateService.Hold", []HelloArgs{{}}), http.StatusServiceUnavailable, rpc.ErrQueueTimeout.Error())
+	// Other methods are not limited.
+	expect(t, call(s, "HelloService.Say", []HelloArgs{{}}), http.StatusOK)
+
+	close(gate.release)
+	expectResult(t, <-first, `{"Message":"released"}`)
+}
+
+func TestMethodConcurrencyQueueWait(t *testing.T) {
+	s, gate := newGateServer(t, 0)
+	var waits []time.Duration
+	waited := make(chan struct{}, 2)
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		waits = append(waits, i.QueueWait)
+		waited <- struct{}{}
+	})
+	first := hold(t, s, gate)
+	second := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		second <- call(s, "GateService.Hold", []HelloArgs{{}})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case <-gate.entered:
+		t.Fatal("second call entered the method beyond the limit")
+	default:
+	}
+	gate.release <- struct{}{}
+	expectResult(t, <-first, `{"Message":"released"}`)
+	<-waited
+	<-gate.entered
+	gate.release <- struct{}{}
+	expectResult(t, <-second, `{"Message":"released"}`)
+	<-waited
+
+	if waits[0] != 0 || waits[1] < 20*time.Millisecond {
+		t.Errorf("queue waits = %v, want none then at least 20ms", waits)
+	}
+}
+
+func TestMethodConcurrencyInvalid(t *testing.T) {
+	s := newServer(t)
+	if err := s.SetMethodConcurrency("HelloService.Say", 0, 0); err == nil {
+		t.Error("zero limit accepted")
+	}
+	if err := s.SetMethodConcurrency("HelloService.Missing", 1, 0); err == nil {
+		t.Error("limit set on an unknown method")
+	}
+}
@@ -6,9 +6,11 @@
 package rpc
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"unicode"
@@ -19,6 +21,13 @@ var (
 	// Precompute the reflect.Type of error and http.Request
 	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
 	typeOfRequest = reflect.TypeOf((*http.Request)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+	// Args type of the methods without *args
+	typeOfNoArgs = reflect.TypeOf(&struct{}{})
+	// Receiver type of the services made of registered functions
+	typeOfFuncReceiver = reflect.TypeOf(funcReceiver{})
+	// Receiver type of the built-in "rpc" service
+	typeOfIntrospection = reflect.TypeOf(&introspection{})
 )
 
 // ----------------------------------------------------------------------------
@@ -26,17 +35,47 @@ var (
 // ----------------------------------------------------------------------------
 
 type service struct {
-	name     string                    // name of service
-	rcvr     reflect.Value             // receiver of methods for the service
-	rcvrType reflect.Type              // type of the receiver
-	methods  map[string]*serviceMethod // registered methods
+	name     string                          // name of service
+	rcvr     reflect.Value                   // receiver of methods for the service
+	rcvrType reflect.Type                    // type of the receiver
+	iface    reflect.Type                    // interface restricting the methods
+	catchAll string                          // method serving unknown methods
+	factory  func(*http.Request) interface{} // receiver constructor, if any
+	methods  map[string]*serviceMethod       // registered methods
 }
 
+// funcReceiver is the receiver of the services made of functions
+// registered one by one rather than extracted from a receiver type.
+type funcReceiver struct{}
+
 type serviceMethod struct {
-	method    reflect.Method // receiver method
-	argsType  reflect.Type   // type of the request argument
-	replyType reflect.Type   // type of the response argument
-	authorize reflect.Method
+	method     reflect.Method // receiver method
+	argsType   reflect.Type   // type of the request argument
+	replyType  reflect.Type   // type of the response argument
+	authorize  reflect.Method
+	extraType  reflect.Type   // type of the injected argument, if any
+	streamType reflect.Type   // type of the channel or callback of streamed replies, if any
+	example    *MethodExample // documentation example, if any
+	contextArg bool           // whether the method takes a context.Context instead of the request
+	noArgs     bool           // whether the method takes no *args
+	limit      *methodLimit   // concurrency limit, if any
+	stats      *methodStats   // invocation counters
+}
+
+// MethodNotFoundError is returned when a request names a method that is not
+// registered, either because the service is unknown or because the service
+// has no such method.
+type MethodNotFoundError struct {
+	Service      string // requested service name
+	Method       string // requested method name, without the service
+	ServiceFound bool   // whether the service is registered
+}
+
+func (e *MethodNotFoundError) Error() string {
+	if e.ServiceFound {
+		return fmt.Sprintf("rpc: can't find method %q", e.Service+"."+e.Method)
+	}
+	return fmt.Sprintf("rpc: can't find service %q", e.Service+"."+e.Method)
 }
 
 // ----------------------------------------------------------------------------
@@ -45,88 +84,394 @@ type serviceMethod struct {
 
 // serviceMap is a registry for services.
 type serviceMap struct {
-	mutex    sync.Mutex
-	services map[string]*service
+	mutex        sync.Mutex
+	services     map[string]*service
+	onRegistered func(service, method string, noArgs bool)
+	options      methodOptions
+}
+
+// methodOptions enables the optional method signatures extracted from
+// receivers.
+type methodOptions struct {
+	// Whether methods without *args are extracted.
+	noArgs bool
+	// Whether methods taking an injected argument after *reply are
+	// extracted.
+	injected bool
 }
 
 // register adds a new service using reflection to extract its methods.
-func (m *serviceMap) register(rcvr interface{}, name string) error {
+//
+// If iface is not nil, only the methods declared by that interface type are
+// extracted.
+func (m *serviceMap) register(rcvr interface{}, name string, iface reflect.Type) error {
+	s, err := newService(rcvr, name, iface, m.options)
+	if err != nil {
+		return err
+	}
+	return m.add(s)
+}
+
+// registerFactory adds a new service whose receiver is created for every
+// request by the factory. The methods are extracted from prototype, a
+// receiver of the type returned by the factory.
+func (m *serviceMap) registerFactory(prototype interface{}, factory func(*http.Request) interface{}, name string) error {
+	if prototype == nil {
+		return fmt.Errorf("rpc: prototype of factory service missing")
+	}
+	s, err := newService(prototype, name, nil, m.options)
+	if err != nil {
+		return err
+	}
+	s.factory = factory
+	return m.add(s)
+}
+
+// add adds a service to the map.
+func (m *serviceMap) add(s *service) error {
+	m.mutex.Lock()
+	if m.services == nil {
+		m.services = make(map[string]*service)
+	} else if _, ok := m.services[s.name]; ok {
+		m.mutex.Unlock()
+		return fmt.Errorf("rpc: service already defined: %q", s.name)
+	}
+	m.services[s.name] = s
+	m.mutex.Unlock()
+
+	names := make([]string, 0, len(s.methods))
+	for name := range s.methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m.notify(s.name, name, s.methods[name].noArgs)
+	}
+	return nil
+}
+
+// notify calls the registration callback, if any, for a method added to the
+// map. It must be called without holding the lock.
+func (m *serviceMap) notify(service, method string, noArgs bool) {
+	m.mutex.Lock()
+	onRegistered := m.onRegistered
+	m.mutex.Unlock()
+	if onRegistered != nil {
+		onRegistered(service, method, noArgs)
+	}
+}
+
+// replace atomically swaps the receiver of a registered service, extracting
+// its methods again. Requests already holding the old service finish on it.
+// Method statistics, examples and concurrency limits carry over to the
+// methods kept by the new receiver.
+func (m *serviceMap) replace(rcvr interface{}, name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	old := m.services[name]
+	if old == nil {
+		return fmt.Errorf("rpc: can't find service %q", name)
+	}
+	if old.rcvrType == typeOfFuncReceiver {
+		return fmt.Errorf("rpc: service %q is made of functions", name)
+	}
+	if old.factory != nil {
+		return fmt.Errorf("rpc: service %q is created by a factory", name)
+	}
+	s, err := newService(rcvr, name, old.iface, m.options)
+	if err != nil {
+		return err
+	}
+	for methodName, method := range s.methods {
+		if oldMethod := old.methods[methodName]; oldMethod != nil {
+			method.stats = oldMethod.stats
+			method.example = oldMethod.example
+			method.limit = oldMethod.limit
+		}
+	}
+	if _, ok := s.methods[old.catchAll]; ok {
+		s.catchAll = old.catchAll
+	}
+	m.services[name] = s
+	return nil
+}
+
+// newService returns a new service using reflection to extract its methods.
+// The optional method signatures are only extracted if enabled in options.
+func newService(rcvr interface{}, name string, iface reflect.Type, options methodOptions) (*service, error) {
 	// Setup service.
 
 	s := &service{
 		name:     name,
 		rcvr:     reflect.ValueOf(rcvr),
 		rcvrType: reflect.TypeOf(rcvr),
+		iface:    iface,
 		methods:  make(map[string]*serviceMethod),
 	}
 	if name == "" {
 		s.name = reflect.Indirect(s.rcvr).Type().Name()
 		if !isExported(s.name) {
-			return fmt.Errorf("rpc: type %q is not exported", s.name)
+			return nil, fmt.Errorf("rpc: type %q is not exported", s.name)
 		}
 	}
 	if s.name == "" {
-		return fmt.Errorf("rpc: no service name for type %q",
+		return nil, fmt.Errorf("rpc: no service name for type %q",
 			s.rcvrType.String())
 	}
+	if s.name == introspectionService && s.rcvrType != typeOfIntrospection {
+		return nil, fmt.Errorf("rpc: service name %q is reserved", s.name)
+	}
 
 	// Setup methods.
 	for i := 0; i < s.rcvrType.NumMethod(); i++ {
 
 		method := s.rcvrType.Method(i)
-		mtype := method.Type
 		// Method must be exported.
 		if method.PkgPath != "" {
 			continue
 		}
-		// Method needs four ins: receiver, *http.Request, *args, *reply.
-		if mtype.NumIn() != 4 {
-			continue
-		}
-		// First argument must be a pointer and must be http.Request.
-		reqType := mtype.In(1)
-		if reqType.Kind() != reflect.Ptr || reqType.Elem() != typeOfRequest {
-			continue
+		// Method must be declared by the interface, if any.
+		if iface != nil {
+			if _, ok := iface.MethodByName(method.Name); !ok {
+				continue
+			}
 		}
-		// Second argument must be a pointer and must be exported.
-		args := mtype.In(2)
-		if args.Kind() != reflect.Ptr || !isExportedOrBuiltin(args) {
-			continue
-		}
-		// Third argument must be a pointer and must be exported.
-		reply := mtype.In(3)
-		if reply.Kind() != reflect.Ptr || !isExportedOrBuiltin(reply) {
-			continue
+		if spec := newServiceMethod(method, options); spec != nil {
+			s.methods[method.Name] = spec
 		}
-		// Method needs one out: error.
-		if mtype.NumOut() != 1 {
-			continue
+	}
+	if len(s.methods) == 0 {
+		return nil, fmt.Errorf("rpc: %q has no exported methods of suitable type",
+			s.name)
+	}
+	return s, nil
+}
+
+// newServiceMethod returns the spec of a receiver method, or nil if the
+// method signature is not suitable for an RPC method. Methods without *args
+// or with an injected argument are only suitable if enabled in options.
+func newServiceMethod(method reflect.Method, options methodOptions) *serviceMethod {
+	mtype := method.Type
+	// Method needs four ins: receiver, *http.Request, *args, *reply,
+	// optionally followed by an injected argument, or three ins without
+	// *args, if allowed.
+	if mtype.NumIn() < 3 || mtype.NumIn() > 5 {
+		return nil
+	}
+	noArgs := mtype.NumIn() == 3
+	if noArgs && !options.noArgs {
+		return nil
+	}
+	if mtype.NumIn() == 5 && !options.injected {
+		return nil
+	}
+	// First argument must be a pointer and must be http.Request, or
+	// must be context.Context.
+	reqType := mtype.In(1)
+	contextArg := reqType == typeOfContext
+	if !contextArg && (reqType.Kind() != reflect.Ptr || reqType.Elem() != typeOfRequest) {
+		return nil
+	}
+	// Second argument must be a pointer and must be exported.
+	args := typeOfNoArgs
+	if !noArgs {
+		args = mtype.In(2)
+	}
+	if args.Kind() != reflect.Ptr || !isExportedOrBuiltin(args) {
+		return nil
+	}
+	// Third argument must be a pointer and must be exported, or a
+	// channel or callback receiving such pointers for streamed replies.
+	reply := mtype.In(replyIndex(noArgs))
+	var stream reflect.Type
+	if isStreamType(reply) {
+		stream = reply
+		reply = streamElem(reply)
+	}
+	if reply.Kind() != reflect.Ptr || !isExportedOrBuiltin(reply) {
+		return nil
+	}
+	// Method needs one out: error.
+	if mtype.NumOut() != 1 {
+		return nil
+	}
+	if returnType := mtype.Out(0); returnType != typeOfError {
+		return nil
+	}
+	var extra reflect.Type
+	if mtype.NumIn() == 5 {
+		extra = mtype.In(4)
+	}
+	return &serviceMethod{
+		method:     method,
+		argsType:   args.Elem(),
+		replyType:  reply.Elem(),
+		extraType:  extra,
+		streamType: stream,
+		contextArg: contextArg,
+		noArgs:     noArgs,
+		stats:      new(methodStats),
+	}
+}
+
+// registerFunc adds a single method backed by a function, given a method
+// name in dotted notation as in "Service.Method".
+//
+// The function is called like a receiver method: its first argument is the
+// service receiver, followed by *http.Request, *args and *reply. Functions
+// can only be added to services made of functions.
+func (m *serviceMap) registerFunc(name string, fn reflect.Value, argsType, replyType reflect.Type) error {
+	parts := strings.Split(name, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("rpc: service/method name ill-formed: %q", name)
+	}
+	spec := &serviceMethod{
+		method: reflect.Method{
+			Name: parts[1],
+			Type: fn.Type(),
+			Func: fn,
+		},
+		argsType:  argsType,
+		replyType: replyType,
+		stats:     new(methodStats),
+	}
+	if err := m.addFunc(parts[0], spec); err != nil {
+		return err
+	}
+	m.notify(parts[0], parts[1], false)
+	return nil
+}
+
+// registerFuncMap adds the functions of funcs as methods of the service
+// named namespace, keyed by method name. The functions take the same
+// arguments as receiver methods, without the receiver. Invalid entries are
+// skipped and reported together in the returned error.
+func (m *serviceMap) registerFuncMap(namespace string, funcs map[string]interface{}) error {
+	if namespace == "" {
+		return fmt.Errorf("rpc: no service name for function map")
+	}
+	names := make([]string, 0, len(funcs))
+	for name := range funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var added []*serviceMethod
+	var errs []string
+	for _, name := range names {
+		spec, err := newFuncMethod(name, funcs[name], m.options)
+		if err == nil {
+			err = m.addFunc(namespace, spec)
 		}
-		if returnType := mtype.Out(0); returnType != typeOfError {
+		if err != nil {
+			errs = append(errs, err.Error())
 			continue
 		}
-		s.methods[method.Name] = &serviceMethod{
-			method:    method,
-			argsType:  args.Elem(),
-			replyType: reply.Elem(),
-		}
+		added = append(added, spec)
 	}
-	if len(s.methods) == 0 {
-		return fmt.Errorf("rpc: %q has no exported methods of suitable type",
-			s.name)
+	for _, spec := range added {
+		m.notify(namespace, spec.method.Name, spec.noArgs)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("rpc: invalid function map entries: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// newFuncMethod returns the spec of a method backed by a function taking
+// the arguments of a receiver method, without the receiver. The function is
+// wrapped to take a service receiver first.
+func newFuncMethod(name string, f interface{}, options methodOptions) (*serviceMethod, error) {
+	if name == "" || strings.Contains(name, ".") {
+		return nil, fmt.Errorf("rpc: method name ill-formed: %q", name)
+	}
+	fn := reflect.ValueOf(f)
+	if fn.Kind() != reflect.Func {
+		return nil, fmt.Errorf("rpc: %q is not a function", name)
+	}
+	ftype := fn.Type()
+	if ftype.IsVariadic() {
+		return nil, fmt.Errorf("rpc: function %q is not of suitable type", name)
+	}
+	in := []reflect.Type{typeOfFuncReceiver}
+	for i := 0; i < ftype.NumIn(); i++ {
+		in = append(in, ftype.In(i))
+	}
+	out := make([]reflect.Type, ftype.NumOut())
+	for i := range out {
+		out[i] = ftype.Out(i)
+	}
+	mtype := reflect.FuncOf(in, out, false)
+	spec := newServiceMethod(reflect.Method{
+		Name: name,
+		Type: mtype,
+		Func: reflect.MakeFunc(mtype, func(args []reflect.Value) []reflect.Value {
+			return fn.Call(args[1:])
+		}),
+	}, options)
+	if spec == nil {
+		return nil, fmt.Errorf("rpc: function %q is not of suitable type", name)
+	}
+	return spec, nil
+}
+
+// addFunc adds a method backed by a function to the map.
+func (m *serviceMap) addFunc(serviceName string, spec *serviceMethod) error {
+	methodName := spec.method.Name
+	if serviceName == introspectionService {
+		return fmt.Errorf("rpc: service name %q is reserved", serviceName)
 	}
-	// Add to the map.
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	if m.services == nil {
 		m.services = make(map[string]*service)
-	} else if _, ok := m.services[s.name]; ok {
+	}
+	s := m.services[serviceName]
+	if s == nil {
+		s = &service{
+			name:     serviceName,
+			rcvr:     reflect.ValueOf(funcReceiver{}),
+			rcvrType: typeOfFuncReceiver,
+			methods:  make(map[string]*serviceMethod),
+		}
+		m.services[s.name] = s
+	} else if s.rcvrType != typeOfFuncReceiver {
 		return fmt.Errorf("rpc: service already defined: %q", s.name)
 	}
-	m.services[s.name] = s
+	if _, ok := s.methods[methodName]; ok {
+		return fmt.Errorf("rpc: method already defined: %q", serviceName+"."+methodName)
+	}
+	s.methods[methodName] = spec
 	return nil
 }
 
+// setCatchAll designates a registered method of a service as the method
+// serving the requests to unknown methods of that service.
+func (m *serviceMap) setCatchAll(serviceName, methodName string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	service := m.services[serviceName]
+	if service == nil || service.methods[methodName] == nil {
+		return &MethodNotFoundError{
+			Service:      serviceName,
+			Method:       methodName,
+			ServiceFound: service != nil,
+		}
+	}
+	service.catchAll = methodName
+	return nil
+}
+
+// getCatchAll returns the catch-all method of a service, if any.
+func (m *serviceMap) getCatchAll(serviceName string) (*service, *serviceMethod) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	service := m.services[serviceName]
+	if service == nil || service.catchAll == "" {
+		return nil, nil
+	}
+	return service, service.methods[service.catchAll]
+}
+
 // get returns a registered service given a method name.
 //
 // The method name uses a dotted notation as in "Service.Method".
@@ -137,20 +482,110 @@ func (m *serviceMap) get(method string) (*service, *serviceMethod, error) {
 		return nil, nil, err
 	}
 	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	service := m.services[parts[0]]
-	m.mutex.Unlock()
 	if service == nil {
-		err := fmt.Errorf("rpc: can't find service %q", method)
+		err := &MethodNotFoundError{Service: parts[0], Method: parts[1]}
 		return nil, nil, err
 	}
 	serviceMethod := service.methods[parts[1]]
 	if serviceMethod == nil {
-		err := fmt.Errorf("rpc: can't find method %q", method)
+		err := &MethodNotFoundError{Service: parts[0], Method: parts[1], ServiceFound: true}
 		return nil, nil, err
 	}
 	return service, serviceMethod, nil
 }
 
+// setExample attaches a documentation example to a registered method.
+func (m *serviceMap) setExample(method string, example *MethodExample) error {
+	_, serviceMethod, err := m.get(method)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	serviceMethod.example = example
+	return nil
+}
+
+// examples returns the documentation examples of the methods, keyed by
+// "Service.Method".
+func (m *serviceMap) examples() map[string]MethodExample {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	examples := make(map[string]MethodExample)
+	for _, service := range m.services {
+		for name, method := range service.methods {
+			if method.example != nil {
+				examples[service.name+"."+name] = *method.example
+			}
+		}
+	}
+	return examples
+}
+
+// has returns true if a service is registered under the given name.
+func (m *serviceMap) has(serviceName string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.services[serviceName] != nil
+}
+
+// serviceNames returns the names of the registered services, sorted.
+func (m *serviceMap) serviceNames() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	names := make([]string, 0, len(m.services))
+	for name := range m.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// stats returns a snapshot of the invocation counters of every method,
+// keyed by "Service.Method".
+func (m *serviceMap) stats() map[string]MethodStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stats := make(map[string]MethodStats)
+	for _, service := range m.services {
+		for name, method := range service.methods {
+			stats[service.name+"."+name] = method.stats.snapshot()
+		}
+	}
+	return stats
+}
+
+// replyIndex returns the index of the *reply argument among the ins of a
+// method.
+func replyIndex(noArgs bool) int {
+	if noArgs {
+		return 2
+	}
+	return 3
+}
+
+// isStreamType returns true if a reply argument type is a channel the method
+// can send on, or a callback taking a single argument and returning nothing.
+func isStreamType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Chan:
+		return t.ChanDir()&reflect.SendDir != 0
+	case reflect.Func:
+		return t.NumIn() == 1 && t.NumOut() == 0 && !t.IsVariadic()
+	}
+	return false
+}
+
+// streamElem returns the type of the replies sent on a stream type.
+func streamElem(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Chan {
+		return t.Elem()
+	}
+	return t.In(0)
+}
+
 // isExported returns true of a string is an exported (upper case) name.
 func isExported(name string) bool {
 	rune, _ := utf8.DecodeRuneInString(name)
@@ -165,4 +600,4 @@ func isExportedOrBuiltin(t reflect.Type) bool {
 	// PkgPath will be non-empty even for an exported type,
 	// so we need to check the type name as well.
 	return isExported(t.Name()) || t.PkgPath() == ""
-}
\ No newline at end of file
+}
@@ -0,0 +1,70 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Mux routes requests to several servers by URL path prefix, e.g. one
+// server per API version under "/v1" and "/v2". The matched prefix is
+// stripped from the path before the request is served, so that the servers
+// route resources and mounted services as if they were served at the root.
+type Mux struct {
+	mutex   sync.RWMutex
+	servers map[string]*Server
+}
+
+// NewMux returns a new Mux.
+func NewMux() *Mux {
+	return &Mux{servers: make(map[string]*Server)}
+}
+
+// Handle registers the server serving the requests whose path starts with
+// the given prefix. The longest matching prefix wins. A prefix matches whole
+// path segments only: "/v1" matches "/v1/rpc" but not "/v10/rpc".
+func (m *Mux) Handle(prefix string, server *Server) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.servers["/"+strings.Trim(prefix, "/")] = server
+}
+
+// ServeHTTP serves the request with the server registered for the longest
+// matching prefix, or replies with http.StatusNotFound.
+func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mutex.RLock()
+	var prefix string
+	var server *Server
+	for p, s := range m.servers {
+		if len(p) < len(prefix) || !matchPrefix(r.URL.Path, p) {
+			continue
+		}
+		prefix, server = p, s
+	}
+	m.mutex.RUnlock()
+	if server == nil {
+		WriteError(w, http.StatusNotFound, "rpc: no server for path "+r.URL.Path)
+		return
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	r2.URL.RawPath = ""
+	server.ServeHTTP(w, r2)
+}
+
+// matchPrefix returns true if the path starts with the segments of prefix.
+func matchPrefix(path, prefix string) bool {
+	if prefix == "/" {
+		return true
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
@@ -0,0 +1,41 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/shridarpatil/rpc"
+)
+
+func TestMux(t *testing.T) {
+	v1 := newServer(t)
+	v2 := newServer(t)
+	if err := v2.RegisterService(new(ItemService), ""); err != nil {
+		t.Fatal(err)
+	}
+	v2.MountService("ItemService", "/items")
+	root := newServer(t)
+	if err := root.RegisterService(new(VersionService), "Version"); err != nil {
+		t.Fatal(err)
+	}
+
+	m := rpc.NewMux()
+	m.Handle("/v1", v1)
+	m.Handle("/v2/", v2)
+
+	expectResult(t, serve(m, "POST", "/v1/rpc", `{"method":"HelloService.Say","params":[{"Who":"a"}]}`), `{"Message":"Hello, a!"}`)
+	// The prefix is stripped before routing.
+	expectResult(t, serve(m, "POST", "/v2/items/create", `{"params":{"Name":"b"}}`), `{"Action":"create","Name":"b"}`)
+	// Prefixes match whole segments.
+	expectError(t, serve(m, "POST", "/v10/rpc", `{}`), http.StatusNotFound, "no server for path /v10/rpc")
+
+	m.Handle("/", root)
+	expectResult(t, serve(m, "POST", "/v10/rpc", `{"method":"Version.Get","params":[{}]}`), `{"Message":""}`)
+	// The longest prefix wins.
+	expectResult(t, serve(m, "POST", "/v1", `{"method":"HelloService.Say","params":[{"Who":"c"}]}`), `{"Message":"Hello, c!"}`)
+}
@@ -0,0 +1,316 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var typeOfTime = reflect.TypeOf(time.Time{})
+
+// errorResponse is the schema of the response objects of failed calls.
+var errorResponse = &openAPISchema{
+	Type: "object",
+	Properties: map[string]*openAPISchema{
+		"jsonrpc": {Type: "string", Enum: []string{"2.0"}},
+		"result":  {Nullable: true},
+		"error":   {},
+		"id":      {},
+	},
+}
+
+// OpenAPIInfo holds the metadata of a generated OpenAPI document.
+type OpenAPIInfo struct {
+	Title       string
+	Description string
+	Version     string
+	// BasePath is the path the server is served at. Defaults to "/rpc".
+	BasePath string
+}
+
+// ----------------------------------------------------------------------------
+// OpenAPI document
+// ----------------------------------------------------------------------------
+
+type openAPIDocument struct {
+	OpenAPI    string                      `json:"openapi"`
+	Info       openAPIInfo                 `json:"info"`
+	Paths      map[string]*openAPIPathItem `json:"paths"`
+	Components openAPIComponents           `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*openAPISchema `json:"schemas,omitempty"`
+}
+
+type openAPIPathItem struct {
+	Post *openAPIOperation `json:"post"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Tags        []string                    `json:"tags"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                         `json:"required"`
+	Content  map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema  *openAPISchema `json:"schema"`
+	Example interface{}    `json:"example,omitempty"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+}
+
+// GenerateOpenAPI returns an OpenAPI 3 document describing the registered
+// services, encoded as JSON.
+//
+// Every method is described as a POST operation on BasePath + "/" +
+// "Service.Method", where BasePath is the path the server is served at:
+// the server routes the requests to such paths to the named method. The
+// operations use the request and response objects of the JSON codec. The
+// request body is a {"jsonrpc", "method", "params", "id"} object, whose
+// optional method is the routed one and whose params schema is derived
+// from the method args type, and the response is a {"jsonrpc", "result",
+// "error", "id"} object, whose result schema is derived from the method
+// reply type. Methods without args are described without params.
+// Streaming methods are described with the response object of a single
+// reply, as newline-delimited JSON. Field names follow the "json" struct
+// tags, and fields tagged with `validate:"required"` are marked as
+// required. Examples attached with SetMethodExample are included. The
+// built-in "rpc" service is not included.
+func (s *Server) GenerateOpenAPI(info OpenAPIInfo) ([]byte, error) {
+	basePath := info.BasePath
+	if basePath == "" {
+		basePath = "/rpc"
+	}
+	doc := &openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:       info.Title,
+			Description: info.Description,
+			Version:     info.Version,
+		},
+		Paths: make(map[string]*openAPIPathItem),
+	}
+	g := &schemaGenerator{schemas: make(map[string]*openAPISchema)}
+
+	s.services.mutex.Lock()
+	for _, service := range s.services.services {
+		if service.name == introspectionService {
+			continue
+		}
+		for name, method := range service.methods {
+			fullName := service.name + "." + name
+			request := &openAPISchema{
+				Type: "object",
+				Properties: map[string]*openAPISchema{
+					"jsonrpc": {Type: "string", Enum: []string{"2.0"}},
+					"method":  {Type: "string", Enum: []string{fullName}},
+					"id":      {},
+				},
+			}
+			// Methods without args take no params.
+			if !method.noArgs {
+				request.Properties["params"] = g.schema(method.argsType)
+			}
+			response := &openAPISchema{
+				Type: "object",
+				Properties: map[string]*openAPISchema{
+					"jsonrpc": {Type: "string", Enum: []string{"2.0"}},
+					"result":  g.schema(method.replyType),
+					"error":   {Nullable: true},
+					"id":      {},
+				},
+			}
+			var reqExample, respExample interface{}
+			if method.example != nil {
+				reqExample = map[string]interface{}{"jsonrpc": "2.0", "method": fullName, "params": method.example.Request, "id": 1}
+				respExample = map[string]interface{}{"jsonrpc": "2.0", "result": method.example.Response, "error": nil, "id": 1}
+			}
+			replyContentType := "application/json"
+			if method.streamType != nil {
+				replyContentType = "application/x-ndjson"
+			}
+			op := &openAPIOperation{
+				OperationID: fullName,
+				Tags:        []string{service.name},
+				RequestBody: &openAPIRequestBody{
+					Required: true,
+					Content: map[string]*openAPIMediaType{
+						"application/json": {Schema: request, Example: reqExample},
+					},
+				},
+				Responses: map[string]*openAPIResponse{
+					"200": {
+						Description: "Successful response",
+						Content: map[string]*openAPIMediaType{
+							replyContentType: {Schema: response, Example: respExample},
+						},
+					},
+					"default": {
+						Description: "Error response",
+						Content: map[string]*openAPIMediaType{
+							"application/json": {Schema: errorResponse},
+						},
+					},
+				},
+			}
+			doc.Paths[strings.TrimSuffix(basePath, "/")+"/"+fullName] = &openAPIPathItem{Post: op}
+		}
+	}
+	s.services.mutex.Unlock()
+
+	doc.Components.Schemas = g.schemas
+	return json.Marshal(doc)
+}
+
+// ----------------------------------------------------------------------------
+// schemaGenerator
+// ----------------------------------------------------------------------------
+
+// schemaGenerator derives OpenAPI schemas from Go types. Named struct types
+// are stored as components and referenced, which also handles recursive
+// types.
+type schemaGenerator struct {
+	schemas map[string]*openAPISchema
+	names   map[reflect.Type]string
+}
+
+// schema returns the schema of the given type.
+func (g *schemaGenerator) schema(t reflect.Type) *openAPISchema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.schema(t.Elem())
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return s
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &openAPISchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &openAPISchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json writes byte slices as base64 strings.
+			return &openAPISchema{Type: "string", Format: "byte"}
+		}
+		return &openAPISchema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		if t == typeOfTime {
+			return &openAPISchema{Type: "string", Format: "date-time"}
+		}
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		return &openAPISchema{Ref: "#/components/schemas/" + g.component(t)}
+	}
+	// Interfaces and other kinds accept any value.
+	return &openAPISchema{}
+}
+
+// component registers a named struct type as a component schema and
+// returns its name.
+func (g *schemaGenerator) component(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	if g.names == nil {
+		g.names = make(map[reflect.Type]string)
+	}
+	name := t.Name()
+	if _, taken := g.schemas[name]; taken {
+		name = strings.NewReplacer("/", "_", ".", "_").Replace(t.PkgPath()) + "_" + name
+	}
+	g.names[t] = name
+	// Reserve the name before generating the fields, for recursive types.
+	g.schemas[name] = nil
+	g.schemas[name] = g.structSchema(t)
+	return name
+}
+
+// structSchema returns the object schema of a struct type, following the
+// encoding/json field naming rules.
+func (g *schemaGenerator) structSchema(t reflect.Type) *openAPISchema {
+	s := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+	g.addFields(s, t)
+	return s
+}
+
+// addFields adds the fields of a struct type to an object schema. Fields
+// of embedded structs without a JSON name are promoted, as encoding/json
+// does.
+func (g *schemaGenerator) addFields(s *openAPISchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		fieldType := field.Type
+		if field.Anonymous && name == "" {
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				g.addFields(s, fieldType)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		s.Properties[name] = g.schema(field.Type)
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			if rule == "required" {
+				s.Required = append(s.Required, name)
+				break
+			}
+		}
+	}
+}
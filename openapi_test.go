@@ -0,0 +1,175 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/shridarpatil/rpc"
+)
+
+type CreateUserArgs struct {
+	Name  string `json:"name" validate:"required"`
+	Email string `json:"email,omitempty"`
+	Admin bool   `json:"-"`
+}
+
+type User struct {
+	Id      int64  `json:"id"`
+	Name    string `json:"name"`
+	Manager *User  `json:"manager"`
+}
+
+type UserService struct{}
+
+func (s *UserService) Create(r *http.Request, args *CreateUserArgs, reply *User) error {
+	reply.Name = args.Name
+	return nil
+}
+
+// openAPI generates the OpenAPI document of a server and decodes it.
+func openAPI(t *testing.T, s *rpc.Server, info rpc.OpenAPIInfo) map[string]interface{} {
+	t.Helper()
+	b, err := s.GenerateOpenAPI(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// lookup returns the value at the given path of a decoded JSON document.
+func lookup(t *testing.T, v interface{}, path ...string) interface{} {
+	t.Helper()
+	for i, key := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			t.Fatalf("%v is not an object", path[:i])
+		}
+		if v, ok = m[key]; !ok {
+			t.Fatalf("%v not found", path[:i+1])
+		}
+	}
+	return v
+}
+
+func TestGenerateOpenAPI(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(UserService), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.EnableIntrospection()
+	doc := openAPI(t, s, rpc.OpenAPIInfo{Title: "Users", Version: "1.0"})
+
+	if v := lookup(t, doc, "openapi"); v != "3.0.3" {
+		t.Errorf("openapi = %v", v)
+	}
+	paths := lookup(t, doc, "paths").(map[string]interface{})
+	if len(paths) != 2 {
+		t.Errorf("paths = %v, want HelloService.Say and UserService.Create only", paths)
+	}
+	op := lookup(t, paths, "/rpc/UserService.Create", "post")
+	if id := lookup(t, op, "operationId"); id != "UserService.Create" {
+		t.Errorf("operationId = %v", id)
+	}
+
+	// The request body is the JSON-RPC request object.
+	request := lookup(t, op, "requestBody", "content", "application/json", "schema")
+	if method := lookup(t, request, "properties", "method", "enum"); !jsonEqual(mustMarshal(t, method), `["UserService.Create"]`) {
+		t.Errorf("method enum = %v", method)
+	}
+	if _, ok := request.(map[string]interface{})["required"]; ok {
+		t.Error("method required, want optional for routed requests")
+	}
+	params := lookup(t, request, "properties", "params")
+	if ref := lookup(t, params, "$ref"); ref != "#/components/schemas/CreateUserArgs" {
+		t.Errorf("params $ref = %v", ref)
+	}
+
+	// The response is the JSON-RPC response object.
+	response := lookup(t, op, "responses", "200", "content", "application/json", "schema")
+	for _, member := range []string{"jsonrpc", "result", "error", "id"} {
+		lookup(t, response, "properties", member)
+	}
+	if ref := lookup(t, response, "properties", "result", "$ref"); ref != "#/components/schemas/User" {
+		t.Errorf("result $ref = %v", ref)
+	}
+	lookup(t, op, "responses", "default", "content", "application/json", "schema", "properties", "error")
+
+	// Components follow the json tags.
+	args := lookup(t, doc, "components", "schemas", "CreateUserArgs")
+	if !jsonEqual(mustMarshal(t, lookup(t, args, "properties")), `{"name":{"type":"string"},"email":{"type":"string"}}`) {
+		t.Errorf("CreateUserArgs properties = %v", lookup(t, args, "properties"))
+	}
+	if required := lookup(t, args, "required"); !jsonEqual(mustMarshal(t, required), `["name"]`) {
+		t.Errorf("CreateUserArgs required = %v", required)
+	}
+	if ref := lookup(t, doc, "components", "schemas", "User", "properties", "manager", "$ref"); ref != "#/components/schemas/User" {
+		t.Errorf("manager $ref = %v", ref)
+	}
+}
+
+func TestGenerateOpenAPIBasePath(t *testing.T) {
+	s := newServer(t)
+	doc := openAPI(t, s, rpc.OpenAPIInfo{BasePath: "/api/"})
+	lookup(t, doc, "paths", "/api/HelloService.Say", "post")
+
+	// The server routes the described paths.
+	for path := range lookup(t, doc, "paths").(map[string]interface{}) {
+		expectResult(t, serve(s, "POST", path, `{"params":[{"Who":"a"}]}`), `{"Message":"Hello, a!"}`)
+	}
+}
+
+func TestGenerateOpenAPIExample(t *testing.T) {
+	s := newServer(t)
+	if err := s.SetMethodExample("HelloService.Say", HelloArgs{Who: "a"}, HelloReply{Message: "Hello, a!"}); err != nil {
+		t.Fatal(err)
+	}
+	doc := openAPI(t, s, rpc.OpenAPIInfo{})
+	op := lookup(t, doc, "paths", "/rpc/HelloService.Say", "post")
+	example := lookup(t, op, "requestBody", "content", "application/json", "example")
+	if !jsonEqual(mustMarshal(t, example), `{"jsonrpc":"2.0","method":"HelloService.Say","params":{"Who":"a"},"id":1}`) {
+		t.Errorf("request example = %v", example)
+	}
+	example = lookup(t, op, "responses", "200", "content", "application/json", "example")
+	if !jsonEqual(mustMarshal(t, example), `{"jsonrpc":"2.0","result":{"Message":"Hello, a!"},"error":null,"id":1}`) {
+		t.Errorf("response example = %v", example)
+	}
+}
+
+// mustMarshal encodes a value as JSON.
+func mustMarshal(t *testing.T, v interface{}) json.RawMessage {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestGenerateOpenAPIWithoutArgs(t *testing.T) {
+	s := newServer(t)
+	s.AllowMethodsWithoutArgs()
+	if err := s.RegisterService(new(PingService), ""); err != nil {
+		t.Fatal(err)
+	}
+	doc := openAPI(t, s, rpc.OpenAPIInfo{})
+	for method, wantParams := range map[string]bool{"PingService.Ping": false, "PingService.Echo": true} {
+		op := lookup(t, doc, "paths", "/rpc/"+method, "post")
+		props := lookup(t, op, "requestBody", "content", "application/json", "schema", "properties").(map[string]interface{})
+		if _, ok := props["params"]; ok != wantParams {
+			t.Errorf("%s: params described = %v, want %v", method, ok, wantParams)
+		}
+		if _, ok := props["method"]; !ok {
+			t.Errorf("%s: method not described", method)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+// Reply conventions
+// ----------------------------------------------------------------------------
+
+// Paginated is implemented by replies holding one page of a larger result
+// set. Codecs serialize such replies using a standard envelope made of the
+// page items and the cursor of the next page.
+type Paginated interface {
+	// Items returns the items of the current page.
+	Items() interface{}
+	// NextCursor returns the cursor of the next page, or an empty string
+	// if this is the last page.
+	NextCursor() string
+}
+
+// Redirect is implemented by replies redirecting the client elsewhere. The
+// server sets the Location header and writes the redirect status directly,
+// bypassing the codec.
+type Redirect interface {
+	// Location returns the URL to redirect to.
+	Location() string
+	// Status returns the redirect status code. Zero means 302 Found.
+	Status() int
+}
+
+// Accepted is implemented by replies of methods starting asynchronous work,
+// e.g. a background job. The server responds with 202 Accepted and a
+// Location header pointing at the status of the work, and the reply is
+// still serialized in the body. Redirect replies take precedence.
+type Accepted interface {
+	// Location returns the URL where the status of the work is available.
+	Location() string
+}
+
+// MultiStatus is implemented by replies of bulk methods where some items
+// may fail while others succeed. The server responds with 207 Multi-Status
+// when at least one item failed, and codecs serialize the succeeded results
+// along with the error of each failed item.
+type MultiStatus interface {
+	// Succeeded returns the results of the items that succeeded.
+	Succeeded() interface{}
+	// Failed returns the errors of the items that failed.
+	Failed() []ItemError
+}
+
+// ItemError is the error of one item of a MultiStatus reply.
+type ItemError struct {
+	// Index is the position of the item in the request.
+	Index int
+	// Err is the error the item failed with.
+	Err error
+}
+
+// Linkable is implemented by replies providing navigation links, e.g. to
+// the next and previous pages of a list. The server sends them in a Link
+// header (RFC 8288), keeping them out of the body.
+type Linkable interface {
+	// Links returns the link URLs keyed by relation type, e.g. "next",
+	// "prev", "first" or "last".
+	Links() map[string]string
+}
+
+// ReaderReply is implemented by replies streamed to the client as raw
+// content, e.g. file downloads. The server copies the reader to the response,
+// bypassing the codec, so the content is never held in memory as a whole.
+// If the reader is also an io.Closer, it is closed once copied.
+type ReaderReply interface {
+	// Reader returns the content to stream.
+	Reader() io.Reader
+	// ContentType returns the Content-Type of the content. Empty means
+	// "application/octet-stream".
+	ContentType() string
+}
+
+// TrailerReply is implemented by ReaderReply replies sending HTTP trailers
+// once their content is streamed, e.g. to let clients tell a complete
+// stream from a truncated one.
+type TrailerReply interface {
+	// TrailerNames returns the names of the trailers, declared in the
+	// Trailer header before streaming.
+	TrailerNames() []string
+	// Trailers returns the trailer values once streaming is over. err is
+	// the error that interrupted the stream, if any.
+	Trailers(err error) http.Header
+}
+
+// Raw returns an already serialized JSON value, e.g. taken from a cache, to
+// be set as the reply of a method with a *json.RawMessage reply. The JSON
+// codec embeds it verbatim in the result instead of encoding it again.
+func Raw(b []byte) json.RawMessage {
+	return json.RawMessage(b)
+}
+
+// writeReaderReply streams the content of a ReaderReply to the response.
+func writeReaderReply(w http.ResponseWriter, reply ReaderReply) error {
+	contentType := reply.ContentType()
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	trailer, hasTrailers := reply.(TrailerReply)
+	if hasTrailers {
+		for _, name := range trailer.TrailerNames() {
+			w.Header().Add("Trailer", name)
+		}
+	}
+	reader := reply.Reader()
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	_, err := io.Copy(w, reader)
+	if hasTrailers {
+		for name, values := range trailer.Trailers(err) {
+			w.Header()[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	return err
+}
+
+// linkHeader formats links as the value of a Link header, sorted by
+// relation type.
+func linkHeader(links map[string]string) string {
+	rels := make([]string, 0, len(links))
+	for rel := range links {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	values := make([]string, len(rels))
+	for i, rel := range rels {
+		values[i] = "<" + links[rel] + `>; rel="` + rel + `"`
+	}
+	return strings.Join(values, ", ")
+}
+
+// statusResponse is an http.ResponseWriter replacing the status written by
+// a codec with its own. Server errors written by the codec, e.g. when the
+// reply can't be encoded, are kept.
+type statusResponse struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusResponse) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.wroteHeader = true
+		if status < http.StatusInternalServerError {
+			status = s.status
+		}
+		s.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (s *statusResponse) Write(p []byte) (int, error) {
+	s.WriteHeader(s.status)
+	return s.ResponseWriter.Write(p)
+}
+
+// statusFuncResponse is an http.ResponseWriter passing the status written by
+// a codec through the function registered with Server.RegisterStatusFunc.
+type statusFuncResponse struct {
+	http.ResponseWriter
+	statusFunc func(status int) int
+	status     int
+}
+
+func (s *statusFuncResponse) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+		if remapped := s.statusFunc(status); remapped != 0 {
+			s.status = remapped
+		}
+		s.ResponseWriter.WriteHeader(s.status)
+	}
+}
+
+func (s *statusFuncResponse) Write(p []byte) (int, error) {
+	s.WriteHeader(http.StatusOK)
+	return s.ResponseWriter.Write(p)
+}
+
+// noContentResponse is an http.ResponseWriter discarding the body of a
+// response. Successful responses get the http.StatusNoContent status,
+// except for HEAD requests.
+type noContentResponse struct {
+	http.ResponseWriter
+	head        bool
+	wroteHeader bool
+}
+
+func (n *noContentResponse) WriteHeader(status int) {
+	if n.wroteHeader {
+		return
+	}
+	n.wroteHeader = true
+	if status == http.StatusOK && !n.head {
+		status = http.StatusNoContent
+	}
+	if status == http.StatusNoContent {
+		n.Header().Del("Content-Type")
+	}
+	n.ResponseWriter.WriteHeader(status)
+}
+
+func (n *noContentResponse) Write(p []byte) (int, error) {
+	n.WriteHeader(http.StatusOK)
+	return len(p), nil
+}
+
+// Unwrap returns the wrapped ResponseWriter, for http.ResponseController.
+func (n *noContentResponse) Unwrap() http.ResponseWriter {
+	return n.ResponseWriter
+}
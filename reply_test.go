@@ -0,0 +1,546 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shridarpatil/rpc"
+)
+
+// ----------------------------------------------------------------------------
+// Paginated
+// ----------------------------------------------------------------------------
+
+type ListArgs struct {
+	Cursor string
+}
+
+type ListReply struct {
+	names  []string
+	cursor string
+}
+
+func (l *ListReply) Items() interface{} { return l.names }
+func (l *ListReply) NextCursor() string { return l.cursor }
+
+type ListService struct{}
+
+func (s *ListService) List(r *http.Request, args *ListArgs, reply *ListReply) error {
+	if args.Cursor == "" {
+		reply.names, reply.cursor = []string{"a", "b"}, "2"
+	} else {
+		reply.names = []string{"c"}
+	}
+	return nil
+}
+
+func TestPaginatedReply(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(ListService), ""); err != nil {
+		t.Fatal(err)
+	}
+	w := call(s, "ListService.List", []ListArgs{{}})
+	expectResult(t, w, `{"items":["a","b"],"next_cursor":"2"}`)
+	w = call(s, "ListService.List", []ListArgs{{Cursor: "2"}})
+	expectResult(t, w, `{"items":["c"],"next_cursor":""}`)
+}
+
+// ----------------------------------------------------------------------------
+// Redirect
+// ----------------------------------------------------------------------------
+
+type RedirectReply struct {
+	location string
+	status   int
+}
+
+func (r *RedirectReply) Location() string { return r.location }
+func (r *RedirectReply) Status() int      { return r.status }
+
+type RedirectArgs struct {
+	Status int
+}
+
+type AuthService struct{}
+
+func (s *AuthService) Login(r *http.Request, args *RedirectArgs, reply *RedirectReply) error {
+	reply.location = "https://example.com/authorize"
+	reply.status = args.Status
+	return nil
+}
+
+func TestRedirectReply(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(AuthService), ""); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		status int
+		want   int
+	}{
+		{0, http.StatusFound},
+		{http.StatusSeeOther, http.StatusSeeOther},
+	}
+	for _, test := range tests {
+		w := call(s, "AuthService.Login", []RedirectArgs{{Status: test.status}})
+		expect(t, w, test.want)
+		if location := w.Header().Get("Location"); location != "https://example.com/authorize" {
+			t.Errorf("Location = %q", location)
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("body = %q, want none", w.Body.String())
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// ReaderReply
+// ----------------------------------------------------------------------------
+
+// closeTracker is a reader recording whether it was closed.
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
+type DownloadArgs struct {
+	Name string
+}
+
+type DownloadReply struct {
+	content     *closeTracker
+	contentType string
+}
+
+func (d *DownloadReply) Reader() io.Reader   { return d.content }
+func (d *DownloadReply) ContentType() string { return d.contentType }
+
+type FileService struct {
+	last *closeTracker
+}
+
+func (s *FileService) Download(r *http.Request, args *DownloadArgs, reply *DownloadReply) error {
+	s.last = &closeTracker{Reader: strings.NewReader("content of " + args.Name)}
+	reply.content = s.last
+	if strings.HasSuffix(args.Name, ".txt") {
+		reply.contentType = "text/plain"
+	}
+	return nil
+}
+
+func TestReaderReply(t *testing.T) {
+	s := newServer(t)
+	files := new(FileService)
+	if err := s.RegisterService(files, ""); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		contentType string
+	}{
+		{"a.txt", "text/plain"},
+		{"a.bin", "application/octet-stream"},
+	}
+	for _, test := range tests {
+		w := call(s, "FileService.Download", []DownloadArgs{{Name: test.name}})
+		expect(t, w, http.StatusOK)
+		if body := w.Body.String(); body != "content of "+test.name {
+			t.Errorf("body = %q", body)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != test.contentType {
+			t.Errorf("Content-Type = %q, want %q", ct, test.contentType)
+		}
+		if !files.last.closed {
+			t.Error("reader not closed")
+		}
+	}
+}
+
+// countingSource is an endless reader counting the bytes read from it.
+type countingSource struct {
+	n int64
+}
+
+func (c *countingSource) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+type LargeArgs struct {
+	Size int64
+}
+
+type LargeReply struct {
+	reader io.Reader
+}
+
+func (l *LargeReply) Reader() io.Reader   { return l.reader }
+func (l *LargeReply) ContentType() string { return "" }
+
+type LargeFileService struct {
+	source *countingSource
+}
+
+func (s *LargeFileService) Download(r *http.Request, args *LargeArgs, reply *LargeReply) error {
+	s.source = new(countingSource)
+	reply.reader = io.LimitReader(s.source, args.Size)
+	return nil
+}
+
+// streamRecorder is an http.ResponseWriter counting the bytes written
+// without keeping them, and recording how much of the source was read when
+// the first bytes were written.
+type streamRecorder struct {
+	header    http.Header
+	status    int
+	written   int64
+	source    func() int64
+	readFirst int64
+}
+
+func (s *streamRecorder) Header() http.Header {
+	return s.header
+}
+
+func (s *streamRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+}
+
+func (s *streamRecorder) Write(p []byte) (int, error) {
+	s.WriteHeader(http.StatusOK)
+	if s.written == 0 {
+		s.readFirst = s.source()
+	}
+	s.written += int64(len(p))
+	return len(p), nil
+}
+
+func TestLargeReaderReply(t *testing.T) {
+	s := newServer(t)
+	files := new(LargeFileService)
+	if err := s.RegisterService(files, ""); err != nil {
+		t.Fatal(err)
+	}
+	const size = 8 << 20
+	for _, timing := range []bool{false, true} {
+		r := serveRequest("POST", "/rpc", fmt.Sprintf(`{"method":"LargeFileService.Download","params":[{"Size":%d}]}`, size))
+		if timing {
+			r.Header.Set("X-RPC-Debug-Timing", "1")
+		}
+		w := &streamRecorder{header: make(http.Header), source: func() int64 { return files.source.n }}
+		s.ServeHTTP(w, r)
+		if w.status != http.StatusOK || w.written != size {
+			t.Fatalf("timing %t: status = %d, written = %d, want %d", timing, w.status, w.written, size)
+		}
+		// The content is written as it is read, not buffered.
+		if w.readFirst >= size {
+			t.Errorf("timing %t: %d bytes read before the first write", timing, w.readFirst)
+		}
+		if !timing {
+			continue
+		}
+		if trailer := w.header.Get("Trailer"); trailer != "Server-Timing" {
+			t.Errorf("Trailer = %q", trailer)
+		}
+		if st := w.header.Get("Server-Timing"); !serverTiming.MatchString(st) {
+			t.Errorf("Server-Timing = %q", st)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// TrailerReply
+// ----------------------------------------------------------------------------
+
+// failingReader returns an error once its content is read.
+type failingReader struct {
+	io.Reader
+}
+
+func (f failingReader) Read(p []byte) (int, error) {
+	n, err := f.Reader.Read(p)
+	if err == io.EOF {
+		err = errors.New("disk failure")
+	}
+	return n, err
+}
+
+type ExportReply struct {
+	reader io.Reader
+}
+
+func (e *ExportReply) Reader() io.Reader      { return e.reader }
+func (e *ExportReply) ContentType() string    { return "text/plain" }
+func (e *ExportReply) TrailerNames() []string { return []string{"X-Export-Status"} }
+
+func (e *ExportReply) Trailers(err error) http.Header {
+	status := "complete"
+	if err != nil {
+		status = "failed: " + err.Error()
+	}
+	return http.Header{"X-Export-Status": {status}}
+}
+
+type ExportArgs struct {
+	Fail bool
+}
+
+type ExportService struct{}
+
+func (s *ExportService) Export(r *http.Request, args *ExportArgs, reply *ExportReply) error {
+	reply.reader = strings.NewReader("rows")
+	if args.Fail {
+		reply.reader = failingReader{reply.reader}
+	}
+	return nil
+}
+
+func TestTrailerReply(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(ExportService), ""); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	tests := []struct {
+		fail   bool
+		status string
+	}{
+		{false, "complete"},
+		{true, "failed: disk failure"},
+	}
+	for _, test := range tests {
+		body := fmt.Sprintf(`{"method":"ExportService.Export","params":[{"Fail":%t}]}`, test.fail)
+		resp, err := http.Post(ts.URL, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, declared := resp.Trailer["X-Export-Status"]; !declared {
+			t.Errorf("trailers declared = %v", resp.Trailer)
+		}
+		content, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "rows" {
+			t.Errorf("body = %q", content)
+		}
+		if status := resp.Trailer.Get("X-Export-Status"); status != test.status {
+			t.Errorf("X-Export-Status = %q, want %q", status, test.status)
+		}
+	}
+
+	// Timings are sent along with the trailers of the reply.
+	req, err := http.NewRequest("POST", ts.URL, strings.NewReader(`{"method":"ExportService.Export","params":[{}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-RPC-Debug-Timing", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if status := resp.Trailer.Get("X-Export-Status"); status != "complete" {
+		t.Errorf("X-Export-Status = %q", status)
+	}
+	if timing := resp.Trailer.Get("Server-Timing"); !serverTiming.MatchString(timing) {
+		t.Errorf("Server-Timing = %q", timing)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Accepted
+// ----------------------------------------------------------------------------
+
+type JobReply struct {
+	Id string
+}
+
+func (j *JobReply) Location() string { return "/jobs/" + j.Id }
+
+type JobService struct{}
+
+func (s *JobService) Start(r *http.Request, args *HelloArgs, reply *JobReply) error {
+	reply.Id = "42"
+	return nil
+}
+
+func TestAcceptedReply(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(JobService), ""); err != nil {
+		t.Fatal(err)
+	}
+	w := call(s, "JobService.Start", []HelloArgs{{}})
+	expect(t, w, http.StatusAccepted)
+	if location := w.Header().Get("Location"); location != "/jobs/42" {
+		t.Errorf("Location = %q", location)
+	}
+	if res := decode(t, w); !jsonEqual(res.Result, `{"Id":"42"}`) {
+		t.Errorf("result = %s", res.Result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// MultiStatus
+// ----------------------------------------------------------------------------
+
+type BulkArgs struct {
+	Names []string
+}
+
+type BulkReply struct {
+	names  []string
+	errors []rpc.ItemError
+}
+
+func (b *BulkReply) Succeeded() interface{}  { return b.names }
+func (b *BulkReply) Failed() []rpc.ItemError { return b.errors }
+
+type BulkService struct{}
+
+func (s *BulkService) Create(r *http.Request, args *BulkArgs, reply *BulkReply) error {
+	reply.names = []string{}
+	for i, name := range args.Names {
+		if name == "" {
+			reply.errors = append(reply.errors, rpc.ItemError{Index: i, Err: errors.New("name required")})
+			continue
+		}
+		reply.names = append(reply.names, name)
+	}
+	return nil
+}
+
+func TestMultiStatusReply(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(BulkService), ""); err != nil {
+		t.Fatal(err)
+	}
+	w := call(s, "BulkService.Create", []BulkArgs{{Names: []string{"a", "", "c"}}})
+	expect(t, w, http.StatusMultiStatus)
+	if res := decode(t, w); !jsonEqual(res.Result, `{"succeeded":["a","c"],"failed":[{"index":1,"error":"name required"}]}`) {
+		t.Errorf("result = %s", res.Result)
+	}
+	// Without failed items, the reply is a plain success.
+	w = call(s, "BulkService.Create", []BulkArgs{{Names: []string{"a"}}})
+	expectResult(t, w, `{"succeeded":["a"],"failed":[]}`)
+}
+
+// ----------------------------------------------------------------------------
+// Linkable
+// ----------------------------------------------------------------------------
+
+type PageReply struct {
+	Names []string
+	links map[string]string
+}
+
+func (p *PageReply) Links() map[string]string { return p.links }
+
+type PageService struct{}
+
+func (s *PageService) List(r *http.Request, args *ListArgs, reply *PageReply) error {
+	reply.Names = []string{"a"}
+	if args.Cursor != "" {
+		reply.links = map[string]string{
+			"next": "/rpc/page?cursor=3",
+			"prev": "/rpc/page?cursor=1",
+		}
+	}
+	if args.Cursor == "bad" {
+		return errors.New("bad cursor")
+	}
+	return nil
+}
+
+func TestLinkableReply(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(PageService), ""); err != nil {
+		t.Fatal(err)
+	}
+	w := call(s, "PageService.List", []ListArgs{{Cursor: "2"}})
+	expectResult(t, w, `{"Names":["a"]}`)
+	want := `</rpc/page?cursor=3>; rel="next", </rpc/page?cursor=1>; rel="prev"`
+	if link := w.Header().Get("Link"); link != want {
+		t.Errorf("Link = %q, want %q", link, want)
+	}
+	// No header without links, nor on errors.
+	for _, cursor := range []string{"", "bad"} {
+		w := call(s, "PageService.List", []ListArgs{{Cursor: cursor}})
+		if link := w.Header().Get("Link"); link != "" {
+			t.Errorf("cursor %q: Link = %q, want none", cursor, link)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Encoding failures
+// ----------------------------------------------------------------------------
+
+type BadReply struct {
+	C chan int
+}
+
+type BadAcceptedReply struct {
+	C chan int
+}
+
+func (b *BadAcceptedReply) Location() string { return "/jobs/1" }
+
+type BadService struct{}
+
+func (s *BadService) Plain(r *http.Request, args *HelloArgs, reply *BadReply) error {
+	reply.C = make(chan int)
+	return nil
+}
+
+func (s *BadService) Accepted(r *http.Request, args *HelloArgs, reply *BadAcceptedReply) error {
+	reply.C = make(chan int)
+	return nil
+}
+
+func TestResponseEncodingError(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(BadService), ""); err != nil {
+		t.Fatal(err)
+	}
+	var info rpc.RequestInfo
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		info = *i
+	})
+	for _, method := range []string{"BadService.Plain", "BadService.Accepted"} {
+		w := call(s, method, []HelloArgs{{}})
+		// The status written with the reply is replaced.
+		expectError(t, w, http.StatusInternalServerError, "unsupported type")
+		if info.StatusCode != http.StatusInternalServerError || !errors.Is(info.Error, rpc.ErrResponseEncoding) {
+			t.Errorf("%s: after func status = %d, error = %v", method, info.StatusCode, info.Error)
+		}
+		if stats := s.Stats()[method]; stats.Errors != 1 {
+			t.Errorf("%s: errors = %d, want 1", method, stats.Errors)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// requestIDs generates sequential request ids. The counter comes first to
+// keep it 64-bit aligned for atomic operations.
+type requestIDs struct {
+	counter uint64
+	prefix  string
+}
+
+// EnableSequentialRequestIDs makes the server assign an id to every request,
+// made of nodePrefix and a counter incremented for each request, e.g.
+// "node1-42". If nodePrefix is empty, the server start time is used instead.
+// The id is sent in the X-Request-Id response header and is available to
+// methods with RequestID. When the request carries a W3C trace context,
+// its trace id is sent along in the X-Trace-Id header, so that the request
+// can be found from either id.
+func (s *Server) EnableSequentialRequestIDs(nodePrefix string) {
+	if nodePrefix == "" {
+		nodePrefix = strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	s.requestIDs = &requestIDs{prefix: nodePrefix}
+}
+
+// next returns a new request id.
+func (g *requestIDs) next() string {
+	return g.prefix + "-" + strconv.FormatUint(atomic.AddUint64(&g.counter, 1), 10)
+}
+
+// assignRequestID sets a new request id in the response header and in the
+// request context, along with the trace id of the request, if any. The
+// trace context must already be in the request context.
+func (s *Server) assignRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := s.requestIDs.next()
+	w.Header().Set("X-Request-Id", id)
+	if traceID := TraceID(r.Context()); traceID != "" {
+		w.Header().Set("X-Trace-Id", traceID)
+	}
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey, id))
+}
+
+// RequestID returns the id assigned to the request whose context is ctx when
+// sequential request ids are enabled with
+// Server.EnableSequentialRequestIDs, or an empty string. Log it along with
+// TraceID to link the request to its trace.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
@@ -0,0 +1,75 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/shridarpatil/rpc"
+)
+
+type RequestIDService struct{}
+
+func (s *RequestIDService) Get(ctx context.Context, args *HelloArgs, reply *HelloReply) error {
+	reply.Message = rpc.RequestID(ctx)
+	return nil
+}
+
+func TestSequentialRequestIDs(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(RequestIDService), ""); err != nil {
+		t.Fatal(err)
+	}
+	w := call(s, "RequestIDService.Get", []HelloArgs{{}})
+	if id := w.Header().Get("X-Request-Id"); id != "" {
+		t.Errorf("X-Request-Id = %q, want none", id)
+	}
+	expectResult(t, w, `{"Message":""}`)
+
+	s.EnableSequentialRequestIDs("node1")
+	for _, want := range []string{"node1-1", "node1-2"} {
+		w := call(s, "RequestIDService.Get", []HelloArgs{{}})
+		if id := w.Header().Get("X-Request-Id"); id != want {
+			t.Errorf("X-Request-Id = %q, want %q", id, want)
+		}
+		expectResult(t, w, `{"Message":"`+want+`"}`)
+	}
+}
+
+func TestSequentialRequestIDsDefaultPrefix(t *testing.T) {
+	s := newServer(t)
+	s.EnableSequentialRequestIDs("")
+	id := call(s, "HelloService.Say", []HelloArgs{{}}).Header().Get("X-Request-Id")
+	if !strings.HasSuffix(id, "-1") || len(id) <= len("-1") {
+		t.Errorf("X-Request-Id = %q", id)
+	}
+}
+
+func TestSequentialRequestIDsConcurrent(t *testing.T) {
+	s := newServer(t)
+	s.EnableSequentialRequestIDs("node1")
+	const n = 50
+	var mu sync.Mutex
+	ids := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := call(s, "HelloService.Say", []HelloArgs{{}}).Header().Get("X-Request-Id")
+			mu.Lock()
+			ids[id] = true
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if len(ids) != n {
+		t.Errorf("%d distinct ids, want %d", len(ids), n)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MapResource maps the HTTP methods of a resource path to RPC methods, for
+// REST-flavored access. For example:
+//
+//	s.MapResource("user", map[string]string{
+//		"GET":  "User.List",
+//		"POST": "User.Create",
+//	})
+//
+// routes "GET /rpc/user" to User.List and "POST /rpc/user" to User.Create.
+// A resource matches the trailing segments of the URL path, so it does not
+// depend on where the server is mounted. When several resources match, the
+// longest one wins. Params are read from the URL query for GET requests and
+// from the body otherwise, and the method named by the request itself is
+// ignored. HEAD requests are served by the method mapped to GET, unless
+// mapped themselves. Unmapped HTTP methods on a resource are rejected with
+// 405.
+//
+// The RPC methods use a dotted notation as in "Service.Method".
+func (s *Server) MapResource(resource string, verbMethods map[string]string) {
+	if s.resources == nil {
+		s.resources = make(map[string]map[string]string)
+	}
+	verbs := make(map[string]string, len(verbMethods))
+	for verb, method := range verbMethods {
+		verbs[strings.ToUpper(verb)] = method
+	}
+	s.resources[strings.Trim(resource, "/")] = verbs
+}
+
+// resourceMethod returns the RPC method mapped to the request path and HTTP
+// method. The returned bool reports whether the path matches a resource,
+// even if the HTTP method is not mapped.
+func (s *Server) resourceMethod(r *http.Request) (string, bool) {
+	path := strings.Trim(r.URL.Path, "/")
+	var match string
+	var verbs map[string]string
+	for resource, v := range s.resources {
+		if len(resource) <= len(match) {
+			continue
+		}
+		if path == resource || strings.HasSuffix(path, "/"+resource) {
+			match, verbs = resource, v
+		}
+	}
+	if verbs == nil {
+		return "", false
+	}
+	if method, ok := verbs[r.Method]; ok || r.Method != http.MethodHead {
+		return method, true
+	}
+	return verbs[http.MethodGet], true
+}
+
+// MountService mounts a registered service at a base path. The path segment
+// following the base path selects the method, with its first letter
+// upper-cased: with UserService mounted at "/users/", a request to
+// "/users/create" calls UserService.Create. When several base paths match,
+// the longest one wins.
+//
+// Requests to a mounted base path without a method segment fall back to the
+// method named by the request itself.
+func (s *Server) MountService(name, basePath string) {
+	if s.mounts == nil {
+		s.mounts = make(map[string]string)
+	}
+	s.mounts[strings.TrimSuffix(basePath, "/")+"/"] = name
+}
+
+// SetMethodPathTemplate binds the path segments following the method
+// segment of a mounted service (see MountService) to named params, for
+// RESTful calls. The template lists the param names in braces, e.g.
+// "{id}" or "{org}/{id}": with UserService mounted at "/users/" and the
+// template "{id}" set for UserService.Get, a GET request to
+// "/users/get/123?include=profile" calls UserService.Get with the params
+// id=123 and include=profile.
+//
+// The path params are available to codecs with PathParams. The JSON codec
+// merges them with the params of the URL query for GET requests, and over
+// the params of the body otherwise. Path values are strings: it converts
+// them to the types of the args fields, so that "123" fills an Id int
+// field. Paths whose trailing segments do not match the template of the
+// method are not routed.
+func (s *Server) SetMethodPathTemplate(method, template string) error {
+	var names []string
+	for _, segment := range strings.Split(strings.Trim(template, "/"), "/") {
+		if len(segment) < 3 || segment[0] != '{' || segment[len(segment)-1] != '}' {
+			return fmt.Errorf("rpc: path template ill-formed: %q", template)
+		}
+		names = append(names, segment[1:len(segment)-1])
+	}
+	if s.pathTemplates == nil {
+		s.pathTemplates = make(map[string][]string)
+	}
+	s.pathTemplates[method] = names
+	return nil
+}
+
+// mountedMethod returns the method named by the request path below the base
+// path of a mounted service, along with the params bound by the path
+// template of the method, if any. The returned bool reports whether the
+// path names a method of a mounted service.
+func (s *Server) mountedMethod(r *http.Request) (string, map[string]string, bool) {
+	var base, service string
+	for b, name := range s.mounts {
+		if len(b) > len(base) && strings.HasPrefix(r.URL.Path, b) {
+			base, service = b, name
+		}
+	}
+	if service == "" {
+		return "", nil, false
+	}
+	segment := strings.Trim(r.URL.Path[len(base):], "/")
+	var rest string
+	if i := strings.Index(segment, "/"); i != -1 {
+		segment, rest = segment[:i], segment[i+1:]
+	}
+	if segment == "" {
+		return "", nil, false
+	}
+	first, size := utf8.DecodeRuneInString(segment)
+	method := service + "." + string(unicode.ToUpper(first)) + segment[size:]
+	if rest == "" {
+		return method, nil, true
+	}
+	names := s.pathTemplates[method]
+	values := strings.Split(rest, "/")
+	if len(names) != len(values) {
+		return "", nil, false
+	}
+	params := make(map[string]string, len(names))
+	for i, name := range names {
+		params[name] = values[i]
+	}
+	return method, params, true
+}
+
+// namedMethod returns the method named by the last segment of the request
+// path, as in "/rpc/Service.Method", if it is a registered method.
+func (s *Server) namedMethod(r *http.Request) (string, bool) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	segment := path[strings.LastIndex(path, "/")+1:]
+	if !strings.Contains(segment, ".") {
+		return "", false
+	}
+	if _, _, err := s.services.get(segment); err != nil {
+		return "", false
+	}
+	return segment, true
+}
+
+// PathParams returns the params bound by the path template of the method
+// called by the request whose context is ctx, set with
+// Server.SetMethodPathTemplate, or nil.
+func PathParams(ctx context.Context) map[string]string {
+	params, _ := ctx.Value(pathParamsKey).(map[string]string)
+	return params
+}
+
+// MethodSource is a part of a request that can name the method to call.
+type MethodSource int
+
+const (
+	// MethodSourcePath is the URL path, routed with MapResource or
+	// MountService, or ending with a "Service.Method" segment naming a
+	// registered method, as in "/rpc/HelloService.Say".
+	MethodSourcePath MethodSource = iota
+	// MethodSourceBody is the method read by the codec: from the body, or
+	// from the URL query for the HTTP methods set with
+	// SetQueryParamMethods.
+	MethodSourceBody
+	// MethodSourceQuery is the "method" parameter of the URL query.
+	MethodSourceQuery
+	// MethodSourceHeader is the "X-RPC-Method" header.
+	MethodSourceHeader
+)
+
+// defaultMethodSources is the method source precedence by default.
+var defaultMethodSources = []MethodSource{MethodSourcePath, MethodSourceBody}
+
+// SetMethodSourcePrecedence sets the parts of a request read for the method
+// to call, in order: the first one naming a method wins. For example, with
+// []MethodSource{MethodSourceBody, MethodSourcePath} the method named in the
+// body overrides the route of a mounted service, which only applies to
+// requests without one. Sources left out are ignored; in particular
+// resources and mounted services are not routed without MethodSourcePath.
+//
+// It defaults to MethodSourcePath then MethodSourceBody.
+func (s *Server) SetMethodSourcePrecedence(sources []MethodSource) {
+	s.methodSources = sources
+}
+
+// readsMethodFrom returns true if the method can be read from the given
+// source.
+func (s *Server) readsMethodFrom(source MethodSource) bool {
+	for _, src := range s.methodPrecedence() {
+		if src == source {
+			return true
+		}
+	}
+	return false
+}
+
+// methodPrecedence returns the method source precedence in effect.
+func (s *Server) methodPrecedence() []MethodSource {
+	if s.methodSources == nil {
+		return defaultMethodSources
+	}
+	return s.methodSources
+}
+
+// requestMethod returns the method named by the first source of the method
+// source precedence naming one, given the method routed from the URL path,
+// if any. The returned bool reports whether the method is the routed one.
+// emptyBody reports whether the codec read the method from an empty body.
+//
+// When no source names a method, the error of the codec is returned if the
+// body is a source.
+func (s *Server) requestMethod(r *http.Request, codecReq CodecRequest, pathMethod string, emptyBody bool) (string, bool, error) {
+	errMethod := errors.New("rpc: method name missing")
+	for _, source := range s.methodPrecedence() {
+		switch source {
+		case MethodSourcePath:
+			if pathMethod != "" {
+				return pathMethod, true, nil
+			}
+		case MethodSourceBody:
+			method, err := codecReq.Method()
+			if err == nil {
+				return method, false, nil
+			}
+			if errors.Is(err, ErrReadTimeout) || errors.Is(err, ErrTotalBytesExceeded) {
+				return "", false, err
+			}
+			errMethod = err
+			if emptyBody {
+				errMethod = errors.New("rpc: request body required")
+			}
+		case MethodSourceQuery:
+			if method := r.URL.Query().Get("method"); method != "" {
+				return method, false, nil
+			}
+		case MethodSourceHeader:
+			if method := r.Header.Get("X-RPC-Method"); method != "" {
+				return method, false, nil
+			}
+		}
+	}
+	return "", false, errMethod
+}
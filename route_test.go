@@ -0,0 +1,173 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/shridarpatil/rpc"
+)
+
+type ItemArgs struct {
+	Name string
+}
+
+type ItemReply struct {
+	Action string
+	Name   string
+}
+
+type ItemService struct{}
+
+func (s *ItemService) List(r *http.Request, args *ItemArgs, reply *ItemReply) error {
+	reply.Action, reply.Name = "list", args.Name
+	return nil
+}
+
+func (s *ItemService) Create(r *http.Request, args *ItemArgs, reply *ItemReply) error {
+	reply.Action, reply.Name = "create", args.Name
+	return nil
+}
+
+// newItemServer returns a server with ItemService mapped to the "item"
+// resource.
+func newItemServer(t *testing.T) http.Handler {
+	t.Helper()
+	s := newServer(t)
+	if err := s.RegisterService(new(ItemService), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.MapResource("item", map[string]string{
+		"get":  "ItemService.List",
+		"POST": "ItemService.Create",
+	})
+	return s
+}
+
+func TestMapResource(t *testing.T) {
+	s := newItemServer(t)
+	expectResult(t, serve(s, "GET", "/rpc/item?Name=a", ""), `{"Action":"list","Name":"a"}`)
+	expectResult(t, serve(s, "POST", "/api/v1/item", `{"params":{"Name":"b"}}`), `{"Action":"create","Name":"b"}`)
+	// The method named by the request is ignored.
+	expectResult(t, serve(s, "POST", "/rpc/item", `{"method":"ItemService.List","params":{"Name":"c"}}`), `{"Action":"create","Name":"c"}`)
+	expect(t, serve(s, "DELETE", "/rpc/item", `{}`), http.StatusMethodNotAllowed)
+	// Other paths are not routed.
+	expectResult(t, serve(s, "POST", "/rpc", `{"method":"ItemService.List","params":{"Name":"d"}}`), `{"Action":"list","Name":"d"}`)
+}
+
+func TestMapResourceLongestMatch(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(ItemService), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.MapResource("item", map[string]string{"POST": "ItemService.Create"})
+	s.MapResource("/archived/item/", map[string]string{"POST": "ItemService.List"})
+	expectResult(t, serve(s, "POST", "/rpc/item", `{}`), `{"Action":"create","Name":""}`)
+	expectResult(t, serve(s, "POST", "/rpc/archived/item", `{}`), `{"Action":"list","Name":""}`)
+}
+
+func TestMountService(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(ItemService), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.MountService("ItemService", "/items")
+	s.MountService("HelloService", "/items/hello/")
+	expectResult(t, serve(s, "POST", "/items/create", `{"params":{"Name":"a"}}`), `{"Action":"create","Name":"a"}`)
+	expectResult(t, serve(s, "POST", "/items/list/", `{"params":{"Name":"b"}}`), `{"Action":"list","Name":"b"}`)
+	// The longest base path wins.
+	expectResult(t, serve(s, "POST", "/items/hello/say", `{"params":{"Who":"c"}}`), `{"Message":"Hello, c!"}`)
+	// Without a method segment, the method named by the request is called.
+	expectResult(t, serve(s, "POST", "/items/", `{"method":"ItemService.List","params":{"Name":"d"}}`), `{"Action":"list","Name":"d"}`)
+	expectError(t, serve(s, "POST", "/items/delete", `{}`), http.StatusBadRequest, "method not found")
+}
+
+func TestMethodPath(t *testing.T) {
+	s := newServer(t)
+	expectResult(t, serve(s, "POST", "/rpc/HelloService.Say", `{"params":[{"Who":"a"}]}`), `{"Message":"Hello, a!"}`)
+	// The routed method overrides the method named by the request.
+	expectResult(t, serve(s, "POST", "/api/HelloService.Say/", `{"method":"HelloService.Missing","params":[{"Who":"b"}]}`), `{"Message":"Hello, b!"}`)
+	// Segments not naming a registered method are not routed.
+	expectResult(t, serve(s, "POST", "/rpc/HelloService.Missing", `{"method":"HelloService.Say","params":[{"Who":"c"}]}`), `{"Message":"Hello, c!"}`)
+
+	// Nor are they without MethodSourcePath.
+	s.SetMethodSourcePrecedence([]rpc.MethodSource{rpc.MethodSourceBody})
+	expectResult(t, serve(s, "POST", "/rpc/HelloService.Missing", `{"method":"HelloService.Say","params":[{"Who":"d"}]}`), `{"Message":"Hello, d!"}`)
+	expect(t, serve(s, "POST", "/rpc/HelloService.Say", `{"params":[{"Who":"e"}]}`), http.StatusBadRequest)
+}
+
+func TestMethodSourcePrecedence(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(ItemService), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.MountService("ItemService", "/items/")
+	// By default the route of the mounted service wins over the body.
+	expectResult(t, serve(s, "POST", "/items/create", `{"method":"ItemService.List"}`), `{"Action":"create","Name":""}`)
+
+	s.SetMethodSourcePrecedence([]rpc.MethodSource{rpc.MethodSourceBody, rpc.MethodSourcePath})
+	expectResult(t, serve(s, "POST", "/items/create", `{"method":"ItemService.List"}`), `{"Action":"list","Name":""}`)
+	// The path still applies to requests not naming a method.
+	expectResult(t, serve(s, "POST", "/items/create", `{"params":{"Name":"a"}}`), `{"Action":"create","Name":"a"}`)
+
+	s.SetMethodSourcePrecedence([]rpc.MethodSource{rpc.MethodSourceQuery, rpc.MethodSourceHeader, rpc.MethodSourceBody})
+	r := serveRequest("POST", "/rpc?method=ItemService.Create", `{"method":"ItemService.List"}`)
+	r.Header.Set("X-RPC-Method", "ItemService.List")
+	expectResult(t, serveHTTP(s, r), `{"Action":"create","Name":""}`)
+	r = serveRequest("POST", "/rpc", `{"method":"ItemService.List"}`)
+	r.Header.Set("X-RPC-Method", "ItemService.Create")
+	expectResult(t, serveHTTP(s, r), `{"Action":"create","Name":""}`)
+	expectResult(t, serve(s, "POST", "/rpc", `{"method":"ItemService.List"}`), `{"Action":"list","Name":""}`)
+	// Without the path as a source, mounted services are not routed.
+	expectError(t, serve(s, "POST", "/items/create", `{}`), http.StatusBadRequest, "method name missing")
+}
+
+type MemberArgs struct {
+	Org  string
+	Id   int
+	Name string
+}
+
+type MemberService struct{}
+
+func (s *MemberService) Get(r *http.Request, args *MemberArgs, reply *MemberArgs) error {
+	*reply = *args
+	return nil
+}
+
+func TestMethodPathTemplate(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(MemberService), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.SetQueryParamMethods("GET")
+	s.MountService("MemberService", "/members/")
+	if err := s.SetMethodPathTemplate("MemberService.Get", "{org}/{id}"); err != nil {
+		t.Fatal(err)
+	}
+	var params map[string]string
+	s.RegisterBeforeFunc(func(i *rpc.RequestInfo) {
+		params = rpc.PathParams(i.Request.Context())
+	})
+	want := `{"Org":"acme","Id":7,"Name":"a"}`
+	expectResult(t, serve(s, "GET", "/members/get/acme/7?name=a", ""), want)
+	if !reflect.DeepEqual(params, map[string]string{"org": "acme", "id": "7"}) {
+		t.Errorf("path params = %v", params)
+	}
+	// Path params override the params of the body.
+	expectResult(t, serve(s, "POST", "/members/get/acme/7", `{"params":{"Id":1,"Name":"a"}}`), want)
+	// Paths not matching the template are not routed.
+	expectError(t, serve(s, "POST", "/members/get/acme", `{}`), http.StatusBadRequest, "method name missing")
+	expectError(t, serve(s, "GET", "/members/get/acme/x", ""), http.StatusBadRequest, "cannot unmarshal")
+
+	for _, template := range []string{"", "id", "{id}/{}", "{org}/id"} {
+		if err := s.SetMethodPathTemplate("MemberService.Get", template); err == nil {
+			t.Errorf("template %q accepted", template)
+		}
+	}
+}
@@ -6,14 +6,52 @@
 package rpc
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 var nilErrorValue = reflect.Zero(reflect.TypeOf((*error)(nil)).Elem())
 
+// StatusClientClosedRequest is the non-standard status reported to the
+// after function when the client disconnects before the response is
+// written.
+const StatusClientClosedRequest = 499
+
+// ErrReadTimeout is returned when reading the request body takes longer
+// than the duration configured with SetReadTimeout.
+var ErrReadTimeout = errors.New("rpc: timeout reading request body")
+
+// ErrResponseEncoding is reported to the after function when the codec fails
+// to encode the reply of a successful call, replying with a server error
+// status.
+var ErrResponseEncoding = errors.New("rpc: failed to encode the response")
+
+// RetryableError is implemented by errors telling clients whether the call
+// can be retried. The server sets the X-RPC-Retryable header to "true" or
+// "false", and replies with http.StatusServiceUnavailable to retryable
+// errors. Codecs can also report it in the error object.
+type RetryableError interface {
+	error
+	Retryable() bool
+}
+
+// ErrNotModified is returned by a method to report that the requested data
+// has not changed since the client last read it, e.g. since a cursor given
+// in the args. The server replies with http.StatusNotModified and no body.
+var ErrNotModified = errors.New("rpc: not modified")
+
 // ----------------------------------------------------------------------------
 // Codec
 // ----------------------------------------------------------------------------
@@ -36,6 +74,62 @@ type CodecRequest interface {
 	WriteError(w http.ResponseWriter, status int, err error)
 }
 
+// GETCodec is implemented by codecs that can also serve GET requests.
+//
+// A GET request has no body, so the codec defines how the method name and
+// its params are read from the URL, e.g. from the query string.
+type GETCodec interface {
+	Codec
+	// NewGETRequest returns a CodecRequest for a GET request.
+	NewGETRequest(*http.Request) CodecRequest
+}
+
+// RawParamsReader is implemented by CodecRequests that can expose the
+// request params as raw JSON values keyed by name, for handlers and hooks
+// inspecting params generically.
+type RawParamsReader interface {
+	// RawParams returns the params, or nil if they are not an object.
+	RawParams() map[string]json.RawMessage
+}
+
+// CachedResponder is implemented by CodecRequests that can already hold the
+// response to the request, e.g. in response caching codecs. The server
+// checks it right before invoking the method, once the request went through
+// the intercept and before functions, the validation and the args guard: if
+// a cached response is returned, it is written with WriteResponse and the
+// method is not invoked.
+type CachedResponder interface {
+	// CachedResponse returns the cached reply and true, or false if the
+	// request must be served by the method.
+	CachedResponse() (interface{}, bool)
+}
+
+// ErrorCodec is implemented by codecs that can write errors without a
+// CodecRequest, such as an unsupported HTTP method or Content-Type. The
+// server writes all the errors of a request with the codec registered for
+// a media type listed in the Accept header of the request, so that clients
+// get errors in the format they read. Errors raised once the request is
+// decoded are still written by the CodecRequest when its codec is the
+// accepted one.
+type ErrorCodec interface {
+	Codec
+	// WriteError encodes the error and writes it using the given status.
+	WriteError(w http.ResponseWriter, status int, err error)
+}
+
+// ResponseCodec is implemented by codecs that only encode replies, e.g. to
+// write tabular replies as CSV. When the Accept header of a request names
+// such a codec, it writes the reply of a successful call, while the request
+// is decoded, and errors are written, by the codec selected from the
+// Content-Type. If it fails to encode the reply, the server replies with
+// http.StatusNotAcceptable.
+type ResponseCodec interface {
+	Codec
+	// WriteResponse encodes the reply and writes it, or returns an error
+	// without writing anything if the reply can't be encoded.
+	WriteResponse(w http.ResponseWriter, reply interface{}) error
+}
+
 // ----------------------------------------------------------------------------
 // Server
 // ----------------------------------------------------------------------------
@@ -54,27 +148,87 @@ type RequestInfo struct {
 	Error      error
 	Request    *http.Request
 	StatusCode int
+	// RawMethod holds the method as sent by the client, or routed from the
+	// URL path, before the case policy and the method resolver apply.
+	RawMethod string
+	// RawParams holds the undecoded request params when the codec
+	// implements RawParamsReader.
+	RawParams map[string]json.RawMessage
+	// LogFields holds the structured logging fields extracted by the
+	// function registered with RegisterLogFieldsFunc.
+	LogFields map[string]interface{}
+	// Args holds the decoded method args, a pointer to the args type, once
+	// the request is decoded. It is nil for methods without args.
+	Args interface{}
+	// QueueWait is the time spent waiting for the method to be available,
+	// when its concurrency is limited with SetMethodConcurrency.
+	QueueWait time.Duration
 }
 
 // Server serves registered RPC services using registered codecs.
 type Server struct {
-	codecs        map[string]Codec
-	services      *serviceMap
-	interceptFunc func(i *RequestInfo) *http.Request
-	beforeFunc    func(i *RequestInfo)
-	afterFunc     func(i *RequestInfo)
-	validateFunc  reflect.Value
+	codecs           map[string]Codec
+	services         *serviceMap
+	preReadFunc      func(r *http.Request) error
+	invocationCtx    func(r *http.Request) interface{}
+	resolver         func(r *http.Request, rawMethod string) (string, error)
+	casePolicy       CasePolicy
+	queryMethods     []string
+	noContentMethods []string
+	getDefault       string
+	codecSelector    func(r *http.Request) Codec
+	maxValues        int
+	maxTotalBytes    int64
+	interceptFunc    func(i *RequestInfo) *http.Request
+	beforeFunc       func(i *RequestInfo)
+	afterFunc        func(i *RequestInfo)
+	statusFunc       func(i *RequestInfo) int
+	logFieldsFunc    func(i *RequestInfo) map[string]interface{}
+	flagProvider     func(r *http.Request) map[string]bool
+	panicHandler     func(i *RequestInfo, p interface{}) (int, interface{})
+	argsGuard        func(i *RequestInfo, args interface{}) (int, interface{}, bool)
+	invokers         []func(next InvokeFunc) InvokeFunc
+	validateFunc     reflect.Value
+	validators       map[reflect.Type]func(r *RequestInfo, i interface{}) error
+	validateCode     int
+	errorWriter      func(w http.ResponseWriter, status int, msg string)
+	readTimeout      time.Duration
+	trimStrings      bool
+	selectFields     bool
+	hmacSecret       []byte
+	hmacHeader       string
+	requestIDs       *requestIDs
+	requireBody      map[string]bool
+	resources        map[string]map[string]string
+	mounts           map[string]string
+	pathTemplates    map[string][]string
+	methodSources    []MethodSource
+	deprecations     map[string]*deprecation
+	chaos            *chaos
 }
 
 // RegisterCodec adds a new codec to the server.
 //
 // Codecs are defined to process a given serialization scheme, e.g., JSON or
 // XML. A codec is chosen based on the "Content-Type" header from the request,
-// excluding the charset definition.
+// excluding the charset definition. GET requests, once enabled with
+// SetQueryParamMethods, are only served by codecs implementing GETCodec.
 func (s *Server) RegisterCodec(codec Codec, contentType string) {
 	s.codecs[strings.ToLower(contentType)] = codec
 }
 
+// RegisterPreReadFunc registers the specified function as the function
+// that will be called before the request body is read. It can inspect the
+// request headers and reject the request by returning a non-nil error, in
+// which case the body is never read and the server replies with
+// http.StatusBadRequest and the error message.
+//
+// Note: Only one function can be registered, subsequent calls to this
+// method will overwrite all the previous functions.
+func (s *Server) RegisterPreReadFunc(f func(r *http.Request) error) {
+	s.preReadFunc = f
+}
+
 // RegisterInterceptFunc registers the specified function as the function
 // that will be called before every request. The function is allowed to intercept
 // the request e.g. add values to the context.
@@ -99,9 +253,56 @@ func (s *Server) RegisterBeforeFunc(f func(i *RequestInfo)) {
 // the actual Service method. If this function returns a non-nil error, the method
 // won't be invoked and this error will be considered as the method result.
 // The first argument is information about the request, useful for accessing to http.Request.Context()
-// The second argument of this function is the already-unmarshalled *args parameter of the method.
+// The second argument of this function is the already-unmarshalled *args parameter of the method,
+// or nil for methods without args.
+//
+// Note: Only one function can be registered, subsequent calls to this
+// method will overwrite the previous one, along with the functions
+// registered with Validate.
 func (s *Server) RegisterValidateRequestFunc(f func(r *RequestInfo, i interface{}) error) {
 	s.validateFunc = reflect.ValueOf(f)
+	s.validators = nil
+}
+
+// SetValidationErrorStatus sets the HTTP status used when the function
+// registered with RegisterValidateRequestFunc returns an error, e.g.
+// http.StatusUnprocessableEntity. It defaults to http.StatusBadRequest.
+func (s *Server) SetValidationErrorStatus(code int) {
+	s.validateCode = code
+}
+
+// RegisterPanicHandler registers the specified function as the function
+// that will be called when a method panics. It receives the recovered value
+// and returns the HTTP status and the response to write, e.g. to map known
+// panic types to http.StatusBadRequest and others to
+// http.StatusInternalServerError. A zero status means
+// http.StatusInternalServerError. If the response is an error, or nil, it is
+// written as an error; otherwise it is written as the reply.
+//
+// Without a panic handler, panics are not recovered.
+//
+// Note: Only one function can be registered, subsequent calls to this
+// method will overwrite all the previous functions.
+func (s *Server) RegisterPanicHandler(f func(i *RequestInfo, p interface{}) (status int, reply interface{})) {
+	s.panicHandler = f
+}
+
+// RegisterArgsGuard registers the specified function as the function
+// that will be called with the decoded args of every request before
+// invoking the method, to reject requests based on their content. The args
+// are nil for methods without args. When it returns true for reject, the
+// method is not called and the server writes body with the given status
+// instead: an error body is written as an error, any other body as a reply.
+// A nil body writes a generic error, and a zero status means
+// http.StatusForbidden.
+//
+// The guard is called after the validate function, and only if the request
+// passed validation.
+//
+// Note: Only one function can be registered, subsequent calls to this
+// method will overwrite all the previous functions.
+func (s *Server) RegisterArgsGuard(f func(i *RequestInfo, args interface{}) (status int, body interface{}, reject bool)) {
+	s.argsGuard = f
 }
 
 // RegisterAfterFunc registers the specified function as the function
@@ -113,24 +314,388 @@ func (s *Server) RegisterAfterFunc(f func(i *RequestInfo)) {
 	s.afterFunc = f
 }
 
+// RegisterStatusFunc registers the specified function as the function
+// that will be called just before the response status is written, e.g. to
+// remap the status of some errors for quirky clients. It receives the
+// status about to be written in the StatusCode field and returns the status
+// to write instead, or zero to keep it.
+//
+// The function is not called for the errors rejecting a request before its
+// method is resolved, nor for streamed replies once the first reply is sent.
+//
+// Note: Only one function can be registered, subsequent calls to this
+// method will overwrite all the previous functions.
+func (s *Server) RegisterStatusFunc(f func(i *RequestInfo) int) {
+	s.statusFunc = f
+}
+
+// SetErrorWriter registers the function used to write the errors raised
+// before a codec request exists, such as an unsupported HTTP method (405)
+// or Content-Type (415). By default these errors are written by the
+// ErrorCodec accepted by the client, if any, or else by the package-level
+// WriteError, which writes a plain text body.
+func (s *Server) SetErrorWriter(f func(w http.ResponseWriter, status int, msg string)) {
+	s.errorWriter = f
+}
+
+// SetReadTimeout sets the maximum duration allowed for reading the request
+// body. When decoding the request takes longer, the server replies with
+// http.StatusRequestTimeout. The deadline is set on the connection, so
+// that a client stalling mid-body doesn't block the handler. A zero
+// duration disables the limit.
+func (s *Server) SetReadTimeout(d time.Duration) {
+	s.readTimeout = d
+}
+
+// TrimStringParams makes the server trim leading and trailing white space
+// from the string fields of the method args once they are decoded, e.g. to
+// clean up query parameters of GET requests. Only exported string fields
+// are trimmed, including those of nested structs: strings held in slices
+// and maps are left untouched.
+func (s *Server) TrimStringParams() {
+	s.trimStrings = true
+}
+
+// EnableFieldSelection lets clients request a subset of the top-level reply
+// fields with the "fields" query parameter, e.g. "?fields=id,name". Codecs
+// read the requested fields with SelectedFields. The parameter is left out
+// of the query given to GETCodec.NewGETRequest, so that it is not decoded
+// into the args.
+func (s *Server) EnableFieldSelection() {
+	s.selectFields = true
+}
+
+// SetQueryParamMethods sets the HTTP methods whose requests carry the method
+// name and params in the URL query, read with GETCodec.NewGETRequest, e.g.
+// "GET" and "DELETE". Requests with any other HTTP method, such as POST,
+// PUT or PATCH, read them from the body.
+//
+// No method reads the query by default. As GET requests have no body, they
+// are rejected with http.StatusMethodNotAllowed unless "GET" is listed, and
+// calling this method again without it disables them: a method reachable
+// with GET can be invoked by a link or a prefetch, without the consent of
+// the user. Only list it for methods safe to call that way. HEAD requests
+// are served like GET ones, see SetNoContentMethods.
+func (s *Server) SetQueryParamMethods(methods ...string) {
+	s.queryMethods = make([]string, len(methods))
+	for i, method := range methods {
+		s.queryMethods[i] = strings.ToUpper(method)
+	}
+}
+
+// SetGETDefaultCodec sets the codec serving the GET requests without a
+// Content-Type, given the content type it is registered for. By default
+// such requests are served by the only codec implementing GETCodec, and
+// rejected with http.StatusUnsupportedMediaType when there are several.
+//
+// Requests fail with http.StatusInternalServerError if no GETCodec is
+// registered for the content type.
+func (s *Server) SetGETDefaultCodec(contentType string) {
+	s.getDefault = strings.ToLower(contentType)
+}
+
+// SetCodecSelector registers a function selecting the codec of each request,
+// e.g. to parse versioned vendor media types such as
+// "application/vnd.myapp.v2+json". It overrides the selection by
+// Content-Type, which still applies when the function returns nil.
+// NegotiatedContentType returns the content type the selected codec is
+// registered for, or the Content-Type of the request if it is not
+// registered.
+//
+// Note: Only one function can be registered, subsequent calls to this
+// method will overwrite the previous one.
+func (s *Server) SetCodecSelector(f func(r *http.Request) Codec) {
+	s.codecSelector = f
+}
+
+// SetMaxValuesPerParam limits how many times a parameter can be repeated in
+// the URL query of the requests reading their params from the query, as in
+// "?id=1&id=2". Requests exceeding it are rejected with
+// http.StatusBadRequest. Zero means no limit, the default.
+func (s *Server) SetMaxValuesPerParam(n int) {
+	s.maxValues = n
+}
+
+// SetNoContentMethods sets the HTTP methods whose responses have no body,
+// e.g. "DELETE" for clients expecting 204 No Content. Successful calls are
+// answered with http.StatusNoContent, discarding the reply. Errors are
+// answered with their status and the error message in the X-RPC-Error
+// header, as for HEAD requests, whose responses never have a body.
+func (s *Server) SetNoContentMethods(methods ...string) {
+	s.noContentMethods = make([]string, len(methods))
+	for i, method := range methods {
+		s.noContentMethods[i] = strings.ToUpper(method)
+	}
+}
+
+// noContent returns true if the responses to requests with the given HTTP
+// method have no body.
+func (s *Server) noContent(httpMethod string) bool {
+	if httpMethod == http.MethodHead {
+		return true
+	}
+	for _, m := range s.noContentMethods {
+		if m == httpMethod {
+			return true
+		}
+	}
+	return false
+}
+
+// readsQuery returns true if requests with the given HTTP method read the
+// params from the URL query. HEAD requests read it along with GET ones.
+func (s *Server) readsQuery(httpMethod string) bool {
+	if httpMethod == http.MethodHead && s.readsQuery(http.MethodGet) {
+		return true
+	}
+	for _, m := range s.queryMethods {
+		if m == httpMethod {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireBody marks the given method as requiring a request body. Calls to
+// it without a body, such as a bodyless POST routed from the URL path or a
+// GET, are rejected with http.StatusBadRequest instead of invoking the
+// method with empty args. The body is checked by reading it, so that empty
+// chunked bodies are rejected as well.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (s *Server) RequireBody(method string) {
+	if s.requireBody == nil {
+		s.requireBody = make(map[string]bool)
+	}
+	s.requireBody[method] = true
+}
+
+// CasePolicy defines how the service and method names sent by clients map to
+// the Go names of the registered services and methods.
+type CasePolicy int
+
+const (
+	// CaseExact requires the Go names, as in "HelloService.Say". This is
+	// the default policy.
+	CaseExact CasePolicy = iota
+	// CaseTitleFirst upper-cases the first letter of the names, accepting
+	// both "helloService.say" and "HelloService.Say".
+	CaseTitleFirst
+	// CaseLowerFirst requires names starting with a lower case letter, as
+	// in "helloService.say", for camelCase APIs.
+	CaseLowerFirst
+)
+
+// SetCasePolicy sets how the method names read by the codecs map to the
+// registered methods. It applies before the method resolver, and not to the
+// methods routed from the URL path. It defaults to CaseExact.
+func (s *Server) SetCasePolicy(policy CasePolicy) {
+	s.casePolicy = policy
+}
+
+// SetMethodResolver registers a function that maps the method named by the
+// request, or mapped from a resource, to the registered method to call. It
+// receives the raw method and the request and returns the canonical
+// "Service.Method" name. A non-nil error rejects the request with
+// http.StatusBadRequest.
+//
+// This gives full control over method resolution, e.g. to strip tenant
+// prefixes or resolve versioned aliases.
+func (s *Server) SetMethodResolver(f func(r *http.Request, rawMethod string) (string, error)) {
+	s.resolver = f
+}
+
+// SetInvocationContext registers the function providing the value injected
+// into methods declaring a fourth parameter after *reply, e.g. a database
+// handle:
+//
+//	func (s *Service) Get(r *http.Request, args *Args, reply *Reply, db *sql.DB) error
+//
+// Such methods are only exposed by the services registered afterwards:
+// without the function, they would be called with the zero value. The
+// function is called once per request to such methods. A nil value injects
+// the zero value of the parameter type, and a value not assignable to it
+// fails the request with http.StatusInternalServerError.
+func (s *Server) SetInvocationContext(f func(r *http.Request) interface{}) {
+	s.invocationCtx = f
+	s.services.mutex.Lock()
+	defer s.services.mutex.Unlock()
+	s.services.options.injected = f != nil
+}
+
+// RegisterLogFieldsFunc registers the specified function as the function
+// extracting structured logging fields from every request, e.g. a user id
+// or tenant. It is called once per request before the BeforeFunc, and its
+// result is available as RequestInfo.LogFields to the before, validate and
+// after functions.
+//
+// Note: Only one function can be registered, subsequent calls to this
+// method will overwrite all the previous functions.
+func (s *Server) RegisterLogFieldsFunc(f func(i *RequestInfo) map[string]interface{}) {
+	s.logFieldsFunc = f
+}
+
+// RegisterFlagProvider registers the function evaluating the feature flags
+// of a request. It is called once per request, before any other hook, and
+// its result is available to hooks and methods with Flags(r.Context()), so
+// that they all see the same snapshot.
+//
+// Note: Only one function can be registered, subsequent calls to this
+// method will overwrite all the previous functions.
+func (s *Server) RegisterFlagProvider(f func(r *http.Request) map[string]bool) {
+	s.flagProvider = f
+}
+
+// OnMethodRegistered registers the specified function as the function that
+// will be called for every method added to the server, e.g. to log the API
+// surface at startup. noArgs reports whether the method takes no *args.
+// Methods skipped because of their signature are not reported, nor are the
+// methods registered before.
+//
+// Note: Only one function can be registered, subsequent calls to this
+// method will overwrite all the previous functions.
+func (s *Server) OnMethodRegistered(f func(service, method string, noArgs bool)) {
+	s.services.mutex.Lock()
+	defer s.services.mutex.Unlock()
+	s.services.onRegistered = f
+}
+
+// AllowMethodsWithoutArgs makes the services registered afterwards expose
+// the methods taking no *args, as in
+//
+//	func (s *Service) Now(r *http.Request, reply *time.Time) error
+//
+// They are not exposed by default, as helpers of a receiver such as
+// func (s *Service) Authorize(r *http.Request, u *User) error have the same
+// signature and would become callable methods. Check the methods exposed
+// with OnMethodRegistered before enabling it.
+func (s *Server) AllowMethodsWithoutArgs() {
+	s.services.mutex.Lock()
+	defer s.services.mutex.Unlock()
+	s.services.options.noArgs = true
+}
+
+// EnableIntrospection registers the built-in "rpc" service, whose Stats
+// method returns the same statistics as Server.Stats and whose Examples
+// method returns the examples set with Server.SetMethodExample. It is
+// disabled by default, as any client can call it to learn the traffic and
+// the API surface of the server.
+func (s *Server) EnableIntrospection() {
+	if !s.services.has(introspectionService) {
+		s.services.register(&introspection{server: s}, introspectionService, nil)
+	}
+}
+
 // RegisterService adds a new service to the server.
 //
 // The name parameter is optional: if empty it will be inferred from
-// the receiver type name.
+// the receiver type name. The "rpc" name is reserved for built-in methods,
+// even when they are not enabled.
 //
 // Methods from the receiver will be extracted if these rules are satisfied:
 //
-//    - The receiver is exported (begins with an upper case letter) or local
-//      (defined in the package registering the service).
-//    - The method name is exported.
-//    - The method has three arguments: *http.Request, *args, *reply.
-//    - All three arguments are pointers.
-//    - The second and third arguments are exported or local.
-//    - The method has return type error.
+//   - The receiver is exported (begins with an upper case letter) or local
+//     (defined in the package registering the service).
+//   - The method name is exported.
+//   - The method has three arguments: *http.Request, *args, *reply,
+//     optionally followed by a value injected with SetInvocationContext,
+//     once it is registered.
+//     The first argument can also be a context.Context, receiving the
+//     request context.
+//   - The *args argument can be left out, for methods without params, once
+//     enabled with AllowMethodsWithoutArgs.
+//   - The *http.Request, *args and *reply arguments are pointers.
+//   - The second and third arguments are exported or local.
+//   - The method has return type error.
+//
+// The reply pointer can also be replaced by a channel or a callback of
+// reply pointers to stream several replies; see StreamWriter.
 //
 // All other methods are ignored.
 func (s *Server) RegisterService(receiver interface{}, name string) error {
-	return s.services.register(receiver, name)
+	return s.services.register(receiver, name, nil)
+}
+
+// RegisterServiceFactory adds a new service whose receiver is created for
+// every request, for services holding request-scoped state. The factory
+// must always return receivers of the type of prototype, whose methods
+// follow the rules of RegisterService.
+//
+// The methods are extracted from prototype, such as new(T), which is never
+// called: the factory is only called for requests. The name parameter is
+// optional as in RegisterService.
+func (s *Server) RegisterServiceFactory(name string, prototype interface{}, factory func(r *http.Request) interface{}) error {
+	return s.services.registerFactory(prototype, factory, name)
+}
+
+// RegisterFuncMap adds the functions of funcs as methods of the service
+// named namespace, e.g. for generated dispatch tables. Each function is
+// registered as "namespace.<key>" and must take the arguments of a method
+// registered with RegisterService, without the receiver, as in
+// func(*http.Request, *args, *reply) error.
+//
+// Valid entries are registered even if others are not; the returned error
+// then lists all the invalid entries. The namespace can be shared with
+// methods registered with Method, but not with a service registered with
+// RegisterService.
+func (s *Server) RegisterFuncMap(namespace string, funcs map[string]interface{}) error {
+	return s.services.registerFuncMap(namespace, funcs)
+}
+
+// RegisterCatchAll designates a registered method as the catch-all method
+// of its service: requests to unknown methods of the service are served by
+// it instead of failing. The catch-all method reads the requested method
+// name with UnmatchedMethod(r.Context()). Declaring its args as a
+// json.RawMessage gives it the undecoded params with the JSON codec.
+//
+// Both names are given separately, e.g. ("Proxy", "Forward").
+func (s *Server) RegisterCatchAll(service, method string) error {
+	return s.services.setCatchAll(service, method)
+}
+
+// ReplaceService atomically replaces the receiver of a registered service,
+// e.g. for hot reloads. Methods are extracted again from the new receiver,
+// following the same rules as when the service was registered. Requests in
+// flight finish on the old receiver while new requests use the new one.
+func (s *Server) ReplaceService(name string, receiver interface{}) error {
+	if name == introspectionService {
+		return fmt.Errorf("rpc: service name %q is reserved", name)
+	}
+	return s.services.replace(receiver, name)
+}
+
+// RegisterServiceAs adds a new service to the server exposing only the
+// methods declared by an interface. The iface parameter must be a pointer
+// to an interface type implemented by the receiver, e.g. (*Greeter)(nil).
+//
+// The other exported methods of the receiver are not reachable. Methods are
+// otherwise extracted following the same rules as RegisterService.
+func (s *Server) RegisterServiceAs(receiver interface{}, iface interface{}, name string) error {
+	ifaceType := reflect.TypeOf(iface)
+	if ifaceType == nil || ifaceType.Kind() != reflect.Ptr || ifaceType.Elem().Kind() != reflect.Interface {
+		return fmt.Errorf("rpc: %T is not a pointer to an interface", iface)
+	}
+	ifaceType = ifaceType.Elem()
+	if !reflect.TypeOf(receiver).Implements(ifaceType) {
+		return fmt.Errorf("rpc: type %T does not implement %q", receiver, ifaceType.String())
+	}
+	return s.services.register(receiver, name, ifaceType)
+}
+
+// Stats returns the invocation statistics of every registered method,
+// keyed by "Service.Method".
+func (s *Server) Stats() map[string]MethodStats {
+	return s.services.stats()
+}
+
+// SetMethodExample attaches example args and reply payloads to a registered
+// method, for documentation tools. The examples are returned by the built-in
+// "rpc.Examples" method, see EnableIntrospection, and included in
+// GenerateOpenAPI.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (s *Server) SetMethodExample(method string, reqExample, respExample interface{}) error {
+	return s.services.setExample(method, &MethodExample{Request: reqExample, Response: respExample})
 }
 
 // HasMethod returns true if the given method is registered.
@@ -145,61 +710,304 @@ func (s *Server) HasMethod(method string) bool {
 
 // ServeHTTP
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		WriteError(w, http.StatusMethodNotAllowed, "rpc: POST method required, received "+r.Method)
+	start := time.Now()
+	r = withTraceContext(r)
+	if s.requestIDs != nil {
+		r = s.assignRequestID(w, r)
+	}
+	if s.flagProvider != nil {
+		r = r.WithContext(context.WithValue(r.Context(), flagsKey, s.flagProvider(r)))
+	}
+	// Responses to HEAD requests and to the no-content methods have no
+	// body: errors are reported in a header instead.
+	noContent := s.noContent(r.Method)
+	if noContent {
+		w = &noContentResponse{ResponseWriter: w, head: r.Method == http.MethodHead}
+	}
+	// Errors are written in the format accepted by the client, when a
+	// codec is registered for it.
+	errorType, errorCodec := s.acceptedErrorCodec(r)
+	fail := func(status int, msg string) {
+		if noContent {
+			writeHeaderError(w, status, msg)
+			return
+		}
+		s.writeError(w, errorCodec, status, msg)
+	}
+	var method string
+	var routed bool
+	if s.readsMethodFrom(MethodSourcePath) {
+		method, routed = s.resourceMethod(r)
+	}
+	if routed && method == "" {
+		fail(http.StatusMethodNotAllowed, "rpc: "+r.Method+" method not allowed for resource "+r.URL.Path)
 		return
 	}
-	contentType := r.Header.Get("Content-Type")
-	idx := strings.Index(contentType, ";")
-	if idx != -1 {
-		contentType = contentType[:idx]
+	// GET requests mapped by a resource were enabled with MapResource.
+	bodyless := r.Method == http.MethodGet || r.Method == http.MethodHead
+	fromQuery := s.readsQuery(r.Method) || (routed && bodyless)
+	if !fromQuery && bodyless {
+		fail(http.StatusMethodNotAllowed, "rpc: "+r.Method+" method not allowed")
+		return
 	}
+	if !routed && s.readsMethodFrom(MethodSourcePath) {
+		var pathParams map[string]string
+		if method, pathParams, routed = s.mountedMethod(r); pathParams != nil {
+			r = r.WithContext(context.WithValue(r.Context(), pathParamsKey, pathParams))
+		}
+	}
+	if !routed && s.readsMethodFrom(MethodSourcePath) {
+		method, routed = s.namedMethod(r)
+	}
+	// Some intermediaries send several comma-separated types: the first one
+	// with a registered codec is used.
+	contentTypes := strings.Split(r.Header.Get("Content-Type"), ",")
+	for i, ct := range contentTypes {
+		if idx := strings.Index(ct, ";"); idx != -1 {
+			ct = ct[:idx]
+		}
+		contentTypes[i] = strings.TrimSpace(ct)
+	}
+	contentType := contentTypes[0]
 	var codec Codec
-	if contentType == "" && len(s.codecs) == 1 {
+	if s.codecSelector != nil {
+		codec = s.codecSelector(r)
+	}
+	if codec != nil {
+		// Selected by the registered codec selector.
+		if registered := s.codecContentType(codec, contentTypes); registered != "" {
+			contentType = registered
+		}
+	} else if contentType == "" && fromQuery && s.getDefault != "" {
+		contentType = s.getDefault
+		if _, ok := s.codecs[contentType].(GETCodec); !ok {
+			fail(http.StatusInternalServerError, "rpc: default GET codec not registered: "+contentType)
+			return
+		}
+		codec = s.codecs[contentType]
+	} else if contentType == "" && fromQuery {
+		// GET requests rarely set a Content-Type: default to the codec
+		// supporting GET, as long as there is only one.
+		getCodecs := s.getCodecs()
+		if len(getCodecs) != 1 {
+			fail(http.StatusUnsupportedMediaType, "rpc: Content-Type required to select a GET codec")
+			return
+		}
+		for ct, c := range getCodecs {
+			contentType, codec = ct, c
+		}
+	} else if contentType == "" && len(s.codecs) == 1 {
 		// If Content-Type is not set and only one codec has been registered,
 		// then default to that codec.
-		for _, c := range s.codecs {
-			codec = c
+		for ct, c := range s.codecs {
+			contentType, codec = ct, c
 		}
-	} else if codec = s.codecs[strings.ToLower(contentType)]; codec == nil {
-		WriteError(w, http.StatusUnsupportedMediaType, "rpc: unrecognized Content-Type: "+contentType)
+	} else if contentType, codec = s.lookupCodec(contentTypes); codec == nil {
+		fail(http.StatusUnsupportedMediaType, "rpc: unrecognized Content-Type: "+contentType)
 		return
 	}
+	// Call the registered Pre-Read Function
+	if s.preReadFunc != nil {
+		if err := s.preReadFunc(r); err != nil {
+			fail(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	// Set up the request context shared by the codec, hooks and method.
+	r = r.WithContext(context.WithValue(r.Context(), warningsKey, new(warnings)))
+	cache := new(cacheControl)
+	r = r.WithContext(context.WithValue(r.Context(), cacheControlKey, cache))
+	r = r.WithContext(context.WithValue(r.Context(), contentTypeKey, strings.ToLower(contentType)))
+	if fields := r.URL.Query().Get("fields"); s.selectFields && fields != "" {
+		r = r.WithContext(context.WithValue(r.Context(), fieldsKey, strings.Split(fields, ",")))
+	}
+	var timeout *timeoutReader
+	if s.readTimeout > 0 {
+		timeout = newTimeoutReader(w, r.Body, s.readTimeout)
+		defer timeout.stop()
+		r.Body = timeout
+	}
+	body := &countingReader{ReadCloser: r.Body}
+	r.Body = body
+	counter := &countingResponse{ResponseWriter: w}
+	w = counter
+	var budget *budgetResponse
+	if s.maxTotalBytes > 0 {
+		r.Body = &budgetReader{countingReader: body, max: s.maxTotalBytes}
+		budget = &budgetResponse{ResponseWriter: counter, body: body, max: s.maxTotalBytes}
+		defer budget.flush()
+		w = budget
+	}
+	if s.hmacSecret != nil {
+		if status, err := s.verifyHMAC(r); err != nil {
+			fail(status, err.Error())
+			return
+		}
+	}
 	// Create a new codec request.
-	codecReq := codec.NewRequest(r)
+	var codecReq CodecRequest
+	if fromQuery && s.maxValues > 0 {
+		for key, values := range r.URL.Query() {
+			if len(values) > s.maxValues {
+				fail(http.StatusBadRequest, fmt.Sprintf("rpc: too many values for query parameter %q", key))
+				return
+			}
+		}
+	}
+	// Codecs can't tell an empty body from a truncated one: peek at it.
+	emptyBody := peekEmptyBody(r)
+	if fromQuery {
+		getCodec, ok := codec.(GETCodec)
+		if !ok {
+			fail(http.StatusMethodNotAllowed, "rpc: "+r.Method+" not supported for Content-Type: "+contentType)
+			return
+		}
+		getReq := r
+		if s.selectFields {
+			// The fields to select are not params.
+			getReq = withoutQueryParam(r, "fields")
+		}
+		codecReq = getCodec.NewGETRequest(getReq)
+	} else {
+		codecReq = codec.NewRequest(r)
+	}
+	codecErr := codecReq.WriteError
+	if errorCodec != nil && errorType != strings.ToLower(contentType) {
+		codecErr = errorCodec.WriteError
+	}
+	writeErr := func(w http.ResponseWriter, status int, err error) {
+		if noContent {
+			writeHeaderError(w, status, err.Error())
+			return
+		}
+		codecErr(w, status, err)
+	}
 	// Get service method to be called.
-	method, errMethod := codecReq.Method()
+	method, routed, errMethod := s.requestMethod(r, codecReq, method, emptyBody && !fromQuery)
 	if errMethod != nil {
-		codecReq.WriteError(w, http.StatusBadRequest, errMethod)
+		status := http.StatusBadRequest
+		if errors.Is(errMethod, ErrReadTimeout) {
+			status = http.StatusRequestTimeout
+		} else if errors.Is(errMethod, ErrTotalBytesExceeded) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		writeErr(w, status, errMethod)
+		return
+	}
+	rawMethod := method
+	if !routed && s.casePolicy != CaseExact {
+		var errCase error
+		if method, errCase = s.applyCasePolicy(method); errCase != nil {
+			writeErr(w, http.StatusBadRequest, errCase)
+			return
+		}
+	}
+	if s.resolver != nil {
+		resolved, errResolve := s.resolver(r, method)
+		if errResolve != nil {
+			writeErr(w, http.StatusBadRequest, errResolve)
+			return
+		}
+		method = resolved
+	}
+	if method == "" {
+		writeErr(w, http.StatusBadRequest, errors.New("rpc: method name missing"))
 		return
 	}
 	serviceSpec, methodSpec, errGet := s.services.get(method)
-	fmt.Println("%v, %v", serviceSpec, methodSpec)
+	if nf, ok := errGet.(*MethodNotFoundError); ok && nf.ServiceFound {
+		if catchService, catchMethod := s.services.getCatchAll(nf.Service); catchMethod != nil {
+			serviceSpec, methodSpec, errGet = catchService, catchMethod, nil
+			r = r.WithContext(context.WithValue(r.Context(), unmatchedMethodKey, method))
+		}
+	}
+	if nf, ok := errGet.(*MethodNotFoundError); ok && method != rawMethod {
+		// Report the method as sent by the client.
+		if parts := strings.Split(rawMethod, "."); len(parts) == 2 {
+			nf.Service, nf.Method = parts[0], parts[1]
+		}
+	}
 	if errGet != nil {
-		codecReq.WriteError(w, http.StatusBadRequest, errGet)
+		writeErr(w, http.StatusBadRequest, errGet)
 		return
 	}
+	if s.requireBody[method] && emptyBody {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("rpc: request body required for method %q", method))
+		return
+	}
+	if dep := s.deprecated(method); dep != nil {
+		dep.setHeaders(w.Header())
+		if dep.gone(time.Now()) {
+			writeErr(w, http.StatusGone, fmt.Errorf("rpc: service %q is retired", dep.service))
+			return
+		}
+		if dep.message != "" {
+			AddWarning(r.Context(), dep.message)
+		}
+	}
 	// Decode the args.
 	args := reflect.New(methodSpec.argsType)
-	if errRead := codecReq.ReadRequest(args.Interface()); errRead != nil {
-		codecReq.WriteError(w, http.StatusBadRequest, errRead)
+	if methodSpec.noArgs {
+		// The params are ignored.
+	} else if errRead := codecReq.ReadRequest(args.Interface()); errRead != nil {
+		status := http.StatusBadRequest
+		if errors.Is(errRead, ErrReadTimeout) {
+			status = http.StatusRequestTimeout
+		} else if errors.Is(errRead, ErrTotalBytesExceeded) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		writeErr(w, status, errRead)
 		return
 	}
+	if timeout != nil {
+		// The deadline must not interrupt the connection while the
+		// method runs.
+		timeout.stop()
+	}
+	if budget != nil && int64(body.n) > budget.max {
+		// Codecs may still decode the params of a body over budget.
+		writeErr(w, http.StatusRequestEntityTooLarge, ErrTotalBytesExceeded)
+		return
+	}
+	if s.trimStrings {
+		trimStrings(args)
+	}
+	var argsValue interface{}
+	if !methodSpec.noArgs {
+		argsValue = args.Interface()
+	}
+	var timing *timings
+	if r.Header.Get("X-RPC-Debug-Timing") == "1" {
+		timing = &timings{decode: time.Since(start)}
+	}
 
 	// Call the registered Intercept Function
 	if s.interceptFunc != nil {
 		req := s.interceptFunc(&RequestInfo{
-			Request: r,
-			Method:  method,
+			Request:   r,
+			Method:    method,
+			RawMethod: rawMethod,
+			Args:      argsValue,
 		})
 		if req != nil {
 			r = req
 		}
 	}
 
+	var rawParams map[string]json.RawMessage
+	if rp, ok := codecReq.(RawParamsReader); ok {
+		rawParams = rp.RawParams()
+	}
+
 	requestInfo := &RequestInfo{
-		Request: r,
-		Method:  method,
+		Request:   r,
+		Method:    method,
+		RawMethod: rawMethod,
+		Args:      argsValue,
+		RawParams: rawParams,
+	}
+	if s.logFieldsFunc != nil {
+		requestInfo.LogFields = s.logFieldsFunc(requestInfo)
 	}
 
 	// Call the registered Before Function
@@ -211,54 +1019,592 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	reply := reflect.New(methodSpec.replyType)
 	errValue := []reflect.Value{nilErrorValue}
 
+	// Streaming methods write their replies while they run.
+	var stream *replyStream
+	if methodSpec.streamType != nil {
+		writer, ok := codecReq.(StreamWriter)
+		if !ok {
+			writeErr(w, http.StatusNotImplemented, errors.New("rpc: codec does not support streamed replies"))
+			return
+		}
+		stream = &replyStream{w: w, writer: writer}
+		w.Header().Set("x-content-type-options", "nosniff")
+	}
+
+	rcvr := serviceSpec.rcvr
+	if serviceSpec.factory != nil {
+		rcvr = reflect.ValueOf(serviceSpec.factory(r))
+		if !rcvr.IsValid() || rcvr.Type() != serviceSpec.rcvrType {
+			writeErr(w, http.StatusInternalServerError, fmt.Errorf("rpc: factory of service %q returned a receiver of another type", serviceSpec.name))
+			return
+		}
+	}
+	in := []reflect.Value{rcvr, reflect.ValueOf(r), args, reply}
+	if methodSpec.noArgs {
+		in = []reflect.Value{rcvr, reflect.ValueOf(r), reply}
+	}
+	if methodSpec.contextArg {
+		in[1] = reflect.ValueOf(r.Context())
+	}
+	if methodSpec.extraType != nil {
+		extra, errExtra := s.invocationValue(r, methodSpec.extraType)
+		if errExtra != nil {
+			writeErr(w, http.StatusInternalServerError, errExtra)
+			return
+		}
+		in = append(in, extra)
+	}
+
 	// Call the registered Validator Function
+	errStatus := http.StatusBadRequest
+	phaseStart := time.Now()
 	if s.validateFunc.IsValid() {
-		errValue = s.validateFunc.Call([]reflect.Value{reflect.ValueOf(requestInfo), args})
+		validateArgs := args
+		if methodSpec.noArgs {
+			validateArgs = reflect.Zero(s.validateFunc.Type().In(1))
+		}
+		errValue = s.validateFunc.Call([]reflect.Value{reflect.ValueOf(requestInfo), validateArgs})
+		if !errValue[0].IsNil() && s.validateCode != 0 {
+			errStatus = s.validateCode
+		}
+	}
+	if timing != nil {
+		timing.validate = time.Since(phaseStart)
+	}
+
+	// Let the args guard reject the request.
+	var rejected bool
+	var guardStatus int
+	var guardBody interface{}
+	if errValue[0].IsNil() && s.argsGuard != nil {
+		guardStatus, guardBody, rejected = s.argsGuard(requestInfo, argsValue)
+	}
+
+	// Let the codec short-circuit the call with a cached response, once the
+	// request is accepted.
+	if cr, ok := codecReq.(CachedResponder); ok && errValue[0].IsNil() && !rejected {
+		if cached, ok := cr.CachedResponse(); ok {
+			w.Header().Set("x-content-type-options", "nosniff")
+			codecReq.WriteResponse(w, cached)
+			methodSpec.stats.record(time.Since(start), false, body, counter)
+			if s.afterFunc != nil {
+				requestInfo.StatusCode = http.StatusOK
+				s.afterFunc(requestInfo)
+			}
+			return
+		}
+	}
+
+	// Wait for a slot if the concurrency of the method is limited.
+	var queueWait time.Duration
+	var release func()
+	if errValue[0].IsNil() && !rejected && methodSpec.limit != nil {
+		var errWait error
+		release, queueWait, errWait = methodSpec.limit.acquire(r.Context())
+		if errWait != nil {
+			errValue = []reflect.Value{reflect.ValueOf(errWait)}
+			errStatus = http.StatusServiceUnavailable
+		}
+	}
+
+	if errValue[0].IsNil() && !rejected && s.chaos != nil {
+		s.chaos.inject(r.Context())
 	}
 
 	// If still no errors after validation, call the method
-	if errValue[0].IsNil() {
-		errValue = methodSpec.method.Func.Call([]reflect.Value{
-			serviceSpec.rcvr,
-			reflect.ValueOf(r),
-			args,
-			reply,
+	phaseStart = time.Now()
+	var panicked bool
+	var panicValue interface{}
+	if errValue[0].IsNil() && !rejected {
+		call := func(in []reflect.Value) []reflect.Value {
+			if stream != nil {
+				return stream.call(methodSpec.method.Func, in, replyIndex(methodSpec.noArgs), methodSpec.streamType)
+			}
+			return methodSpec.method.Func.Call(in)
+		}
+		errValue, panicValue, panicked = s.invoke(func() []reflect.Value {
+			if release != nil {
+				defer release()
+			}
+			if len(s.invokers) > 0 {
+				return s.invokeChain(r, in, methodSpec.contextArg, requestInfo.Args, reply.Interface(), call)
+			}
+			return call(in)
 		})
 	}
+	if timing != nil {
+		timing.invoke = time.Since(phaseStart)
+	}
 
 	// Extract the result to error if needed.
 	var errResult error
 	statusCode := http.StatusOK
-	errInter := errValue[0].Interface()
-	if errInter != nil {
-		statusCode = http.StatusBadRequest
+	// The reply written with its own status, from the panic handler or the
+	// args guard.
+	var statusReply interface{}
+	if panicked {
+		statusCode, statusReply = s.panicHandler(requestInfo, panicValue)
+		if statusCode == 0 {
+			statusCode = http.StatusInternalServerError
+		}
+		if statusReply == nil {
+			errResult = errors.New("rpc: method panicked")
+		}
+	} else if rejected {
+		statusCode, statusReply = guardStatus, guardBody
+		if statusCode == 0 {
+			statusCode = http.StatusForbidden
+		}
+		if statusReply == nil {
+			errResult = errors.New("rpc: request rejected")
+		}
+	} else if errInter := errValue[0].Interface(); errInter != nil {
+		statusCode = errStatus
 		errResult = errInter.(error)
 	}
+	if err, ok := statusReply.(error); ok {
+		errResult = err
+		statusReply = nil
+	}
+
+	// The request context is canceled when the client disconnects: there
+	// is nobody left to read the response.
+	clientGone := errors.Is(r.Context().Err(), context.Canceled)
+	if clientGone {
+		statusCode = StatusClientClosedRequest
+		errResult = r.Context().Err()
+	}
 
 	// Prevents Internet Explorer from MIME-sniffing a response away
 	// from the declared content-type
 	w.Header().Set("x-content-type-options", "nosniff")
+	var grpcErr *GRPCStatusError
+	if errResult != nil && errors.As(errResult, &grpcErr) {
+		statusCode = grpcErr.Code.HTTPStatus()
+	}
+	var retryable RetryableError
+	if errResult != nil && errors.As(errResult, &retryable) {
+		w.Header().Set("X-RPC-Retryable", strconv.FormatBool(retryable.Retryable()))
+		if retryable.Retryable() {
+			statusCode = http.StatusServiceUnavailable
+		}
+	}
+	if directives := cache.header(); directives != "" && (errResult == nil || errors.Is(errResult, ErrNotModified)) {
+		w.Header().Set("Cache-Control", directives)
+	}
+	if linkable, ok := reply.Interface().(Linkable); ok && errResult == nil && statusReply == nil {
+		if links := linkable.Links(); len(links) > 0 {
+			w.Header().Set("Link", linkHeader(links))
+		}
+	}
 
-	// Encode the response.
-	if errResult == nil {
-		codecReq.WriteResponse(w, reply.Interface())
+	// Encode the response. When timings are reported, the response is
+	// buffered so that the encode duration can still go in the headers.
+	// Streamed replies are not buffered: reader replies send the timings in
+	// a trailer instead.
+	out := w
+	var buffered *bufferedResponse
+	_, readerReply := reply.Interface().(ReaderReply)
+	readerReply = readerReply && errResult == nil && statusReply == nil
+	if timing != nil && readerReply {
+		w.Header().Add("Trailer", "Server-Timing")
+	} else if timing != nil && stream == nil {
+		buffered = &bufferedResponse{w: w}
+		out = buffered
+	}
+	var remapped *statusFuncResponse
+	if s.statusFunc != nil {
+		remapped = &statusFuncResponse{ResponseWriter: out, statusFunc: func(status int) int {
+			return s.statusFunc(&RequestInfo{
+				Request:    r,
+				Method:     method,
+				RawMethod:  rawMethod,
+				Error:      errResult,
+				StatusCode: status,
+				Args:       argsValue,
+			})
+		}}
+		out = remapped
+	}
+	phaseStart = time.Now()
+	if clientGone {
+		// Skip writing the response.
+	} else if stream != nil && stream.count > 0 {
+		// The replies are already written, along with the status.
+		statusCode = http.StatusOK
+		if errResult != nil {
+			stream.writer.WriteStreamError(out, errResult)
+		} else {
+			errResult = stream.err
+		}
+	} else if errors.Is(errResult, ErrNotModified) {
+		statusCode = http.StatusNotModified
+		errResult = nil
+		out.WriteHeader(statusCode)
+	} else if errResult != nil {
+		writeErr(out, statusCode, errResult)
+	} else if statusReply != nil {
+		codecReq.WriteResponse(&statusResponse{ResponseWriter: out, status: statusCode}, statusReply)
+	} else if stream != nil {
+		// The method returned without sending any reply.
+		out.WriteHeader(http.StatusOK)
+	} else if redirect, ok := reply.Interface().(Redirect); ok {
+		statusCode = redirect.Status()
+		if statusCode == 0 {
+			statusCode = http.StatusFound
+		}
+		out.Header().Set("Location", redirect.Location())
+		out.WriteHeader(statusCode)
+	} else if download, ok := reply.Interface().(ReaderReply); ok {
+		errResult = writeReaderReply(out, download)
+	} else if accepted, ok := reply.Interface().(Accepted); ok {
+		statusCode = http.StatusAccepted
+		out.Header().Set("Location", accepted.Location())
+		codecReq.WriteResponse(&statusResponse{ResponseWriter: out, status: statusCode}, reply.Interface())
+	} else if multi, ok := reply.Interface().(MultiStatus); ok && len(multi.Failed()) > 0 {
+		statusCode = http.StatusMultiStatus
+		codecReq.WriteResponse(&statusResponse{ResponseWriter: out, status: statusCode}, reply.Interface())
+	} else if responseCodec := s.acceptedResponseCodec(r); responseCodec != nil {
+		if err := responseCodec.WriteResponse(out, reply.Interface()); err != nil {
+			statusCode, errResult = http.StatusNotAcceptable, err
+			writeErr(out, statusCode, errResult)
+		}
 	} else {
-		codecReq.WriteError(w, statusCode, errResult)
+		codecReq.WriteResponse(out, reply.Interface())
+	}
+	if remapped != nil && remapped.status != 0 {
+		statusCode = remapped.status
+	}
+	if buffered != nil && !clientGone {
+		timing.encode = time.Since(phaseStart)
+		w.Header().Set("Server-Timing", timing.String())
+		buffered.flush()
+	} else if timing != nil && readerReply {
+		timing.encode = time.Since(phaseStart)
+		w.Header().Set("Server-Timing", timing.String())
+	}
+
+	if budget != nil && budget.exceeded {
+		statusCode, errResult = http.StatusInternalServerError, ErrTotalBytesExceeded
+	} else if errResult == nil && !clientGone && counter.status >= http.StatusInternalServerError {
+		statusCode, errResult = counter.status, ErrResponseEncoding
 	}
 
+	methodSpec.stats.record(time.Since(start), errResult != nil || panicked, body, counter)
+
 	// Call the registered After Function
 	if s.afterFunc != nil {
 		s.afterFunc(&RequestInfo{
 			Request:    r,
 			Method:     method,
+			RawMethod:  rawMethod,
 			Error:      errResult,
 			StatusCode: statusCode,
+			Args:       argsValue,
+			QueueWait:  queueWait,
+			RawParams:  rawParams,
+			LogFields:  requestInfo.LogFields,
 		})
 	}
 }
 
+// applyCasePolicy maps a method name sent by a client to the Go names of the
+// service and method, according to the case policy.
+func (s *Server) applyCasePolicy(method string) (string, error) {
+	parts := strings.Split(method, ".")
+	if len(parts) != 2 {
+		// Left for the service map to reject.
+		return method, nil
+	}
+	for i, part := range parts {
+		first, size := utf8.DecodeRuneInString(part)
+		if s.casePolicy == CaseLowerFirst && !unicode.IsLower(first) {
+			requested := strings.Split(method, ".")
+			return "", &MethodNotFoundError{
+				Service:      requested[0],
+				Method:       requested[1],
+				ServiceFound: i == 1 && s.services.has(parts[0]),
+			}
+		}
+		parts[i] = string(unicode.ToUpper(first)) + part[size:]
+	}
+	return parts[0] + "." + parts[1], nil
+}
+
+// lookupCodec returns the first of the given content types a codec is
+// registered for, along with that codec. It returns the first content type
+// and a nil codec if there is none.
+func (s *Server) lookupCodec(contentTypes []string) (string, Codec) {
+	for _, contentType := range contentTypes {
+		if codec := s.codecs[strings.ToLower(contentType)]; codec != nil {
+			return contentType, codec
+		}
+	}
+	return contentTypes[0], nil
+}
+
+// codecContentType returns the content type a codec is registered for, or
+// "" if it is not registered. Among several, the first of the requested
+// content types is preferred.
+func (s *Server) codecContentType(codec Codec, contentTypes []string) string {
+	if !reflect.TypeOf(codec).Comparable() {
+		return ""
+	}
+	for _, contentType := range contentTypes {
+		contentType = strings.ToLower(contentType)
+		if c, ok := s.codecs[contentType]; ok && c == codec {
+			return contentType
+		}
+	}
+	registered := make([]string, 0, len(s.codecs))
+	for contentType, c := range s.codecs {
+		if c == codec {
+			registered = append(registered, contentType)
+		}
+	}
+	if len(registered) == 0 {
+		return ""
+	}
+	sort.Strings(registered)
+	return registered[0]
+}
+
+// invoke calls a method. When a panic handler is registered, a panic is
+// recovered and returned instead of the method results.
+func (s *Server) invoke(call func() []reflect.Value) (out []reflect.Value, p interface{}, panicked bool) {
+	if s.panicHandler != nil {
+		defer func() {
+			if out == nil {
+				p, panicked = recover(), true
+			}
+		}()
+	}
+	return call(), nil, false
+}
+
+// invocationValue returns the value injected into a method parameter of the
+// given type.
+func (s *Server) invocationValue(r *http.Request, t reflect.Type) (reflect.Value, error) {
+	var value interface{}
+	if s.invocationCtx != nil {
+		value = s.invocationCtx(r)
+	}
+	if value == nil {
+		return reflect.Zero(t), nil
+	}
+	v := reflect.ValueOf(value)
+	if !v.Type().AssignableTo(t) {
+		return reflect.Value{}, fmt.Errorf("rpc: invocation context of type %s not assignable to %s", v.Type(), t)
+	}
+	return v, nil
+}
+
+// getCodecs returns the registered codecs supporting GET requests, keyed by
+// content type.
+func (s *Server) getCodecs() map[string]Codec {
+	codecs := make(map[string]Codec)
+	for contentType, c := range s.codecs {
+		if _, ok := c.(GETCodec); ok {
+			codecs[contentType] = c
+		}
+	}
+	return codecs
+}
+
+// writeError writes an error using the registered error writer, if any, or
+// else the given codec accepted by the client.
+func (s *Server) writeError(w http.ResponseWriter, codec ErrorCodec, status int, msg string) {
+	if s.errorWriter != nil {
+		s.errorWriter(w, status, msg)
+		return
+	}
+	if codec != nil {
+		codec.WriteError(w, status, errors.New(msg))
+		return
+	}
+	WriteError(w, status, msg)
+}
+
+// acceptedErrorCodec returns the first registered ErrorCodec whose media
+// type is listed in the Accept header of the request, along with that
+// media type, if any.
+func (s *Server) acceptedErrorCodec(r *http.Request) (string, ErrorCodec) {
+	for _, mediaType := range s.acceptedMediaTypes(r) {
+		if errorCodec, ok := s.codecs[mediaType].(ErrorCodec); ok {
+			return mediaType, errorCodec
+		}
+	}
+	return "", nil
+}
+
+// acceptedResponseCodec returns the first registered codec whose media type
+// is listed in the Accept header of the request, if it is a ResponseCodec.
+func (s *Server) acceptedResponseCodec(r *http.Request) ResponseCodec {
+	if mediaTypes := s.acceptedMediaTypes(r); len(mediaTypes) > 0 {
+		responseCodec, _ := s.codecs[mediaTypes[0]].(ResponseCodec)
+		return responseCodec
+	}
+	return nil
+}
+
+// acceptedMediaTypes returns the media types listed in the Accept header of
+// the request that a codec is registered for, in order.
+func (s *Server) acceptedMediaTypes(r *http.Request) []string {
+	var mediaTypes []string
+	for _, mediaType := range strings.Split(r.Header.Get("Accept"), ",") {
+		if idx := strings.Index(mediaType, ";"); idx != -1 {
+			mediaType = mediaType[:idx]
+		}
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		if _, ok := s.codecs[mediaType]; ok {
+			mediaTypes = append(mediaTypes, mediaType)
+		}
+	}
+	return mediaTypes
+}
+
+// writeHeaderError writes an error as the status and X-RPC-Error header of
+// a response without body.
+func writeHeaderError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("X-RPC-Error", msg)
+	w.WriteHeader(status)
+}
+
+// WriteError writes an error message as a plain text response.
 func WriteError(w http.ResponseWriter, status int, msg string) {
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(status)
 	fmt.Fprint(w, msg)
-}
\ No newline at end of file
+}
+
+// trimStrings trims the white space around the settable string fields of a
+// decoded value.
+func trimStrings(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			trimStrings(v.Elem())
+		}
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(strings.TrimSpace(v.String()))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				trimStrings(v.Field(i))
+			}
+		}
+	}
+}
+
+// peekEmptyBody reports whether the request body is empty by reading its
+// first byte, which is put back for the codec.
+func peekEmptyBody(r *http.Request) bool {
+	if r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	var b [1]byte
+	n, err := r.Body.Read(b[:])
+	r.Body = &peekedBody{Reader: io.MultiReader(bytes.NewReader(b[:n]), r.Body), Closer: r.Body}
+	return n == 0 && err == io.EOF
+}
+
+// peekedBody is a request body whose first bytes were read and put back.
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// withoutQueryParam returns a shallow copy of the request whose URL query
+// lacks the given parameter, or the request itself if it has none.
+func withoutQueryParam(r *http.Request, key string) *http.Request {
+	query := r.URL.Query()
+	if _, ok := query[key]; !ok {
+		return r
+	}
+	query.Del(key)
+	u := *r.URL
+	u.RawQuery = query.Encode()
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = &u
+	return r2
+}
+
+// timeoutReader wraps a request body and fails reads once its deadline
+// has passed. The deadline is set on the connection when the ResponseWriter
+// supports it, so that a stalled read is interrupted. Otherwise each read
+// runs in a goroutine, ended by closing the body at the deadline.
+type timeoutReader struct {
+	io.ReadCloser
+	deadline time.Time
+	// rc controls the connection, nil if its deadline can't be set.
+	rc *http.ResponseController
+}
+
+// newTimeoutReader returns a timeoutReader for the body of a request
+// answered with w, allowing d to read it.
+func newTimeoutReader(w http.ResponseWriter, body io.ReadCloser, d time.Duration) *timeoutReader {
+	t := &timeoutReader{ReadCloser: body, deadline: time.Now().Add(d)}
+	if rc := http.NewResponseController(w); rc.SetReadDeadline(t.deadline) == nil {
+		t.rc = rc
+	}
+	return t
+}
+
+func (t *timeoutReader) Read(p []byte) (int, error) {
+	if !time.Now().Before(t.deadline) {
+		return 0, ErrReadTimeout
+	}
+	var n int
+	var err error
+	if t.rc != nil {
+		n, err = t.ReadCloser.Read(p)
+	} else {
+		n, err = t.readUntilDeadline(p)
+	}
+	if !time.Now().Before(t.deadline) {
+		// Any result past the deadline, be it a connection timeout or a
+		// late io.EOF, is a timeout.
+		return n, ErrReadTimeout
+	}
+	if err == io.EOF {
+		t.stop()
+	}
+	return n, err
+}
+
+// readUntilDeadline reads from the body, giving up at the deadline. The
+// body is then closed to interrupt the read, which goes to its own buffer
+// as it may still complete after giving up.
+func (t *timeoutReader) readUntilDeadline(p []byte) (int, error) {
+	type result struct {
+		n   int
+		err error
+	}
+	buf := make([]byte, len(p))
+	done := make(chan result, 1)
+	go func() {
+		n, err := t.ReadCloser.Read(buf)
+		done <- result{n, err}
+	}()
+	timer := time.NewTimer(time.Until(t.deadline))
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		return copy(p, buf[:res.n]), res.err
+	case <-timer.C:
+		t.ReadCloser.Close()
+		return 0, ErrReadTimeout
+	}
+}
+
+// stop clears the deadline of the connection once the body is read, so
+// that it doesn't affect the rest of the request.
+func (t *timeoutReader) stop() {
+	if t.rc != nil {
+		t.rc.SetReadDeadline(time.Time{})
+		t.rc = nil
+	}
+}
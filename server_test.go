@@ -0,0 +1,1495 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shridarpatil/rpc"
+	rpcjson "github.com/shridarpatil/rpc/json"
+)
+
+type HelloArgs struct {
+	Who string
+}
+
+type HelloReply struct {
+	Message string
+}
+
+type HelloService struct{}
+
+func (h *HelloService) Say(r *http.Request, args *HelloArgs, reply *HelloReply) error {
+	reply.Message = "Hello, " + args.Who + "!"
+	return nil
+}
+
+// newServer returns a server with the JSON codec and HelloService.
+func newServer(t *testing.T) *rpc.Server {
+	t.Helper()
+	s := rpc.NewServer()
+	s.RegisterCodec(rpcjson.NewCodec(), "application/json")
+	if err := s.RegisterService(new(HelloService), ""); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// serve serves a request with the given HTTP method, URL and body, sent as
+// JSON unless the body is empty.
+func serve(s http.Handler, httpMethod, url, body string) *httptest.ResponseRecorder {
+	return serveHTTP(s, serveRequest(httpMethod, url, body))
+}
+
+// serveRequest returns a request with the given HTTP method, URL and body,
+// sent as JSON unless the body is empty.
+func serveRequest(httpMethod, url, body string) *http.Request {
+	r := httptest.NewRequest(httpMethod, url, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	return r
+}
+
+// serveHTTP serves a request.
+func serveHTTP(s http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+// call serves a JSON-RPC POST request calling method with the given params.
+func call(s http.Handler, method string, params interface{}) *httptest.ResponseRecorder {
+	b, err := json.Marshal(map[string]interface{}{"method": method, "params": params})
+	if err != nil {
+		panic(err)
+	}
+	return serve(s, "POST", "/rpc", string(b))
+}
+
+// response is a decoded JSON-RPC response.
+type response struct {
+	Version  string          `json:"jsonrpc"`
+	Result   json.RawMessage `json:"result"`
+	Error    json.RawMessage `json:"error"`
+	Warnings []string        `json:"warnings"`
+	Id       json.RawMessage `json:"id"`
+}
+
+// decode decodes a JSON-RPC response, failing the test if it is not one.
+func decode(t *testing.T, w *httptest.ResponseRecorder) *response {
+	t.Helper()
+	res := new(response)
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatalf("response %q is not JSON: %v", w.Body.String(), err)
+	}
+	return res
+}
+
+// expect checks the status of a response.
+func expect(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d; body: %s", w.Code, status, w.Body.String())
+	}
+}
+
+// expectResult checks that a response succeeded with the given result,
+// compared as JSON.
+func expectResult(t *testing.T, w *httptest.ResponseRecorder, want string) {
+	t.Helper()
+	expect(t, w, http.StatusOK)
+	res := decode(t, w)
+	if !jsonEqual(res.Result, want) {
+		t.Fatalf("result = %s, want %s", res.Result, want)
+	}
+}
+
+// expectError checks the status of a failed response and that its error
+// message contains msg.
+func expectError(t *testing.T, w *httptest.ResponseRecorder, status int, msg string) {
+	t.Helper()
+	expect(t, w, status)
+	if !strings.Contains(w.Body.String(), msg) {
+		t.Fatalf("body %q does not contain %q", w.Body.String(), msg)
+	}
+}
+
+// jsonEqual returns true if got and want encode the same JSON value.
+func jsonEqual(got json.RawMessage, want string) bool {
+	var g, w interface{}
+	if json.Unmarshal(got, &g) != nil || json.Unmarshal([]byte(want), &w) != nil {
+		return false
+	}
+	return fmt.Sprint(g) == fmt.Sprint(w)
+}
+
+func TestServeHTTP(t *testing.T) {
+	s := newServer(t)
+	w := call(s, "HelloService.Say", []HelloArgs{{Who: "gopher"}})
+	expectResult(t, w, `{"Message":"Hello, gopher!"}`)
+	if ct := w.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Read timeout
+// ----------------------------------------------------------------------------
+
+// lateEOF is a body returning io.EOF after a delay.
+type lateEOF struct {
+	delay time.Duration
+}
+
+func (l lateEOF) Read(p []byte) (int, error) {
+	time.Sleep(l.delay)
+	return 0, io.EOF
+}
+
+func TestReadTimeoutStalledBody(t *testing.T) {
+	s := newServer(t)
+	s.SetReadTimeout(20 * time.Millisecond)
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := httptest.NewRequest("POST", "/rpc", pr)
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		s.ServeHTTP(w, r)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler blocked on a stalled body")
+	}
+	expectError(t, w, http.StatusRequestTimeout, rpc.ErrReadTimeout.Error())
+}
+
+// readingCodec is a textCodec reading the body of POST requests without
+// closing it.
+type readingCodec struct {
+	textCodec
+}
+
+func (c readingCodec) NewRequest(r *http.Request) rpc.CodecRequest {
+	_, err := io.ReadAll(r.Body)
+	return &textCodecRequest{err: err}
+}
+
+func TestReadTimeoutGoroutines(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(readingCodec{}, "text/plain")
+	if err := s.RegisterService(new(HelloService), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.SetReadTimeout(10 * time.Millisecond)
+	before := runtime.NumGoroutine()
+	var writers []*io.PipeWriter
+	defer func() {
+		for _, pw := range writers {
+			pw.Close()
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		pr, pw := io.Pipe()
+		writers = append(writers, pw)
+		r := httptest.NewRequest("POST", "/rpc", pr)
+		r.Header.Set("Content-Type", "text/plain")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		expectError(t, w, http.StatusRequestTimeout, rpc.ErrReadTimeout.Error())
+	}
+	// The reads of the stalled bodies end with the requests.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("goroutines = %d, want at most %d", n, before)
+	}
+}
+
+func TestReadTimeoutLateEOF(t *testing.T) {
+	s := newServer(t)
+	s.SetReadTimeout(20 * time.Millisecond)
+	r := httptest.NewRequest("POST", "/rpc", lateEOF{delay: 50 * time.Millisecond})
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	expectError(t, w, http.StatusRequestTimeout, rpc.ErrReadTimeout.Error())
+}
+
+func TestReadTimeoutConnection(t *testing.T) {
+	s := newServer(t)
+	s.SetReadTimeout(50 * time.Millisecond)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Announce a longer body than the one sent, then stall.
+	fmt.Fprint(conn, "POST /rpc HTTP/1.1\r\nHost: test\r\nContent-Type: application/json\r\nContent-Length: 100\r\n\r\n{\"method\":")
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestTimeout {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusRequestTimeout)
+	}
+}
+
+func TestReadTimeoutNotReached(t *testing.T) {
+	s := newServer(t)
+	s.SetReadTimeout(time.Second)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL, "application/json", strings.NewReader(`{"method":"HelloService.Say","params":[{"Who":"a"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+type SlowService struct{}
+
+func (s *SlowService) Wait(r *http.Request, args *HelloArgs, reply *HelloReply) error {
+	time.Sleep(100 * time.Millisecond)
+	if err := r.Context().Err(); err != nil {
+		return err
+	}
+	reply.Message = "done"
+	return nil
+}
+
+func TestReadTimeoutMethodOutlivesDeadline(t *testing.T) {
+	s := newServer(t)
+	s.RegisterService(new(SlowService), "")
+	s.SetReadTimeout(30 * time.Millisecond)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL, "application/json", strings.NewReader(`{"method":"SlowService.Wait","params":[{}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "done") {
+		t.Fatalf("status = %d, body = %s", resp.StatusCode, body)
+	}
+}
+
+func TestContentTypeWhitespace(t *testing.T) {
+	s := newServer(t)
+	for _, contentType := range []string{
+		"application/json ; charset=utf-8",
+		"application/json  ",
+		" Application/JSON;charset=utf-8",
+	} {
+		r := httptest.NewRequest("POST", "/rpc", strings.NewReader(`{"method":"HelloService.Say","params":[{"Who":"a"}]}`))
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("Content-Type %q: status = %d, want %d", contentType, w.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestContentTypeList(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(ContentTypeService), ""); err != nil {
+		t.Fatal(err)
+	}
+	body := `{"method":"ContentTypeService.Get","params":[{}]}`
+	for _, contentType := range []string{
+		"text/plain, application/json",
+		"text/plain;charset=utf-8 , Application/JSON; charset=utf-8",
+		"application/json, text/plain",
+	} {
+		r := serveRequest("POST", "/rpc", body)
+		r.Header.Set("Content-Type", contentType)
+		expectResult(t, serveHTTP(s, r), `{"Message":"application/json"}`)
+	}
+	r := serveRequest("POST", "/rpc", body)
+	r.Header.Set("Content-Type", "text/plain, text/xml")
+	w := serveHTTP(s, r)
+	expect(t, w, http.StatusUnsupportedMediaType)
+	// The first content type is reported.
+	if !strings.Contains(w.Body.String(), "unrecognized Content-Type: text/plain") {
+		t.Errorf("body = %q", w.Body.String())
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Hooks
+// ----------------------------------------------------------------------------
+
+func TestRawParams(t *testing.T) {
+	for _, params := range []interface{}{
+		[]HelloArgs{{Who: "gopher"}},
+		HelloArgs{Who: "gopher"},
+	} {
+		s := newServer(t)
+		var who string
+		s.RegisterBeforeFunc(func(i *rpc.RequestInfo) {
+			json.Unmarshal(i.RawParams["Who"], &who)
+		})
+		w := call(s, "HelloService.Say", params)
+		expect(t, w, http.StatusOK)
+		if who != "gopher" {
+			t.Errorf("params %v: raw Who = %q, want %q", params, who, "gopher")
+		}
+	}
+}
+
+func TestRawParamsValidate(t *testing.T) {
+	s := newServer(t)
+	s.RegisterValidateRequestFunc(func(i *rpc.RequestInfo, args interface{}) error {
+		if _, ok := i.RawParams["Who"]; !ok {
+			return fmt.Errorf("Who is required")
+		}
+		return nil
+	})
+	expect(t, call(s, "HelloService.Say", []HelloArgs{{Who: "gopher"}}), http.StatusOK)
+	expectError(t, call(s, "HelloService.Say", []map[string]string{{}}), http.StatusBadRequest, "Who is required")
+}
+
+// ----------------------------------------------------------------------------
+// RequireBody
+// ----------------------------------------------------------------------------
+
+// chunkedRequest returns a POST request with a chunked body, whose length
+// is unknown.
+func chunkedRequest(url, body string) *http.Request {
+	r := httptest.NewRequest("POST", url, io.MultiReader(strings.NewReader(body)))
+	r.Header.Set("Content-Type", "application/json")
+	r.ContentLength = -1
+	r.TransferEncoding = []string{"chunked"}
+	return r
+}
+
+func TestRequireBody(t *testing.T) {
+	s := newServer(t)
+	s.MountService("HelloService", "/hello/")
+
+	// Bodyless calls get empty args by default.
+	expectResult(t, serve(s, "POST", "/hello/say", ""), `{"Message":"Hello, !"}`)
+
+	s.RequireBody("HelloService.Say")
+	expectResult(t, serve(s, "POST", "/hello/say", `{"params":{"Who":"a"}}`), `{"Message":"Hello, a!"}`)
+	expectError(t, serve(s, "POST", "/hello/say", ""), http.StatusBadRequest, "request body required")
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, chunkedRequest("/hello/say", ""))
+	expectError(t, w, http.StatusBadRequest, "request body required")
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, chunkedRequest("/hello/say", `{"params":{"Who":"a"}}`))
+	expectResult(t, w, `{"Message":"Hello, a!"}`)
+}
+
+func TestEmptyBodyWithoutMethod(t *testing.T) {
+	s := newServer(t)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, chunkedRequest("/rpc", ""))
+	expectError(t, w, http.StatusBadRequest, "request body required")
+}
+
+// ----------------------------------------------------------------------------
+// RegisterServiceAs
+// ----------------------------------------------------------------------------
+
+type Greeter interface {
+	Say(r *http.Request, args *HelloArgs, reply *HelloReply) error
+}
+
+type AdminGreeter struct {
+	HelloService
+}
+
+func (a *AdminGreeter) Reset(r *http.Request, args *HelloArgs, reply *HelloReply) error {
+	reply.Message = "reset"
+	return nil
+}
+
+func TestRegisterServiceAs(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterServiceAs(new(AdminGreeter), (*Greeter)(nil), "Greeter"); err != nil {
+		t.Fatal(err)
+	}
+	expectResult(t, call(s, "Greeter.Say", []HelloArgs{{Who: "a"}}), `{"Message":"Hello, a!"}`)
+	if s.HasMethod("Greeter.Reset") {
+		t.Error("Greeter.Reset registered, want only the interface methods")
+	}
+	expectError(t, call(s, "Greeter.Reset", []HelloArgs{{}}), http.StatusBadRequest, "method not found")
+}
+
+func TestRegisterServiceAsInvalid(t *testing.T) {
+	s := newServer(t)
+	var greeter Greeter
+	if err := s.RegisterServiceAs(new(AdminGreeter), greeter, "Greeter"); err == nil {
+		t.Error("nil interface value accepted")
+	}
+	if err := s.RegisterServiceAs(new(AdminGreeter), new(AdminGreeter), "Greeter"); err == nil {
+		t.Error("non-interface type accepted")
+	}
+	if err := s.RegisterServiceAs(new(FailService), (*Greeter)(nil), "Greeter"); err == nil {
+		t.Error("receiver not implementing the interface accepted")
+	}
+}
+
+// readTracker is a body recording whether it was read.
+type readTracker struct {
+	io.Reader
+	read bool
+}
+
+func (r *readTracker) Read(p []byte) (int, error) {
+	r.read = true
+	return r.Reader.Read(p)
+}
+
+func TestPreReadFunc(t *testing.T) {
+	s := newServer(t)
+	s.RegisterPreReadFunc(func(r *http.Request) error {
+		if r.Header.Get("Authorization") == "" {
+			return fmt.Errorf("authorization required")
+		}
+		return nil
+	})
+	body := &readTracker{Reader: strings.NewReader(`{"method":"HelloService.Say","params":[{"Who":"a"}]}`)}
+	r := httptest.NewRequest("POST", "/rpc", body)
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	expectError(t, w, http.StatusBadRequest, "authorization required")
+	if body.read {
+		t.Error("body read by a rejected request")
+	}
+
+	r = httptest.NewRequest("POST", "/rpc", strings.NewReader(`{"method":"HelloService.Say","params":[{"Who":"a"}]}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer token")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	expectResult(t, w, `{"Message":"Hello, a!"}`)
+}
+
+// ----------------------------------------------------------------------------
+// Early errors
+// ----------------------------------------------------------------------------
+
+func TestEarlyErrorDefault(t *testing.T) {
+	s := newServer(t)
+	r := httptest.NewRequest("POST", "/rpc", strings.NewReader("a,b"))
+	r.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	expectError(t, w, http.StatusUnsupportedMediaType, "unrecognized Content-Type: text/csv")
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestSetErrorWriter(t *testing.T) {
+	s := newServer(t)
+	s.SetErrorWriter(func(w http.ResponseWriter, status int, msg string) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "detail": msg})
+	})
+	r := httptest.NewRequest("POST", "/rpc", strings.NewReader("a,b"))
+	r.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	expect(t, w, http.StatusUnsupportedMediaType)
+	if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var problem struct {
+		Status int
+		Detail string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Status != http.StatusUnsupportedMediaType || !strings.Contains(problem.Detail, "text/csv") {
+		t.Errorf("problem = %+v", problem)
+	}
+
+	// GET is not enabled.
+	w = serve(s, "GET", "/rpc", "")
+	expect(t, w, http.StatusMethodNotAllowed)
+	if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Client disconnects
+// ----------------------------------------------------------------------------
+
+type BlockService struct {
+	started chan struct{}
+}
+
+func (s *BlockService) Wait(r *http.Request, args *HelloArgs, reply *HelloReply) error {
+	close(s.started)
+	<-r.Context().Done()
+	reply.Message = "done"
+	return nil
+}
+
+func TestClientGone(t *testing.T) {
+	s := newServer(t)
+	block := &BlockService{started: make(chan struct{})}
+	if err := s.RegisterService(block, ""); err != nil {
+		t.Fatal(err)
+	}
+	var info rpc.RequestInfo
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		info = *i
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	r := serveRequest("POST", "/rpc", `{"method":"BlockService.Wait","params":[{}]}`).WithContext(ctx)
+	go func() {
+		<-block.started
+		cancel()
+	}()
+	w := serveHTTP(s, r)
+	if w.Body.Len() != 0 {
+		t.Errorf("body = %q, want none", w.Body.String())
+	}
+	if info.StatusCode != rpc.StatusClientClosedRequest {
+		t.Errorf("status = %d, want %d", info.StatusCode, rpc.StatusClientClosedRequest)
+	}
+	if !errors.Is(info.Error, context.Canceled) {
+		t.Errorf("error = %v, want %v", info.Error, context.Canceled)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Method resolution
+// ----------------------------------------------------------------------------
+
+func TestMethodResolver(t *testing.T) {
+	s := newServer(t)
+	s.SetMethodResolver(func(r *http.Request, rawMethod string) (string, error) {
+		tenant, method, ok := strings.Cut(rawMethod, ":")
+		if !ok {
+			return rawMethod, nil
+		}
+		if tenant != "acme" {
+			return "", fmt.Errorf("unknown tenant %q", tenant)
+		}
+		return method, nil
+	})
+	expectResult(t, call(s, "acme:HelloService.Say", []HelloArgs{{Who: "a"}}), `{"Message":"Hello, a!"}`)
+	expectResult(t, call(s, "HelloService.Say", []HelloArgs{{Who: "a"}}), `{"Message":"Hello, a!"}`)
+	expectError(t, call(s, "other:HelloService.Say", []HelloArgs{{}}), http.StatusBadRequest, `unknown tenant \"other\"`)
+}
+
+func TestValidationErrorStatus(t *testing.T) {
+	s := newServer(t)
+	s.RegisterValidateRequestFunc(func(i *rpc.RequestInfo, args interface{}) error {
+		if args.(*HelloArgs).Who == "" {
+			return fmt.Errorf("Who is required")
+		}
+		return nil
+	})
+	expectError(t, call(s, "HelloService.Say", []HelloArgs{{}}), http.StatusBadRequest, "Who is required")
+	s.SetValidationErrorStatus(http.StatusUnprocessableEntity)
+	expectError(t, call(s, "HelloService.Say", []HelloArgs{{}}), http.StatusUnprocessableEntity, "Who is required")
+	expect(t, call(s, "HelloService.Say", []HelloArgs{{Who: "a"}}), http.StatusOK)
+}
+
+func TestRegisterStatusFunc(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(FailService), ""); err != nil {
+		t.Fatal(err)
+	}
+	var seen rpc.RequestInfo
+	s.RegisterStatusFunc(func(i *rpc.RequestInfo) int {
+		seen = *i
+		if i.Error != nil && i.StatusCode == http.StatusBadRequest {
+			return http.StatusOK
+		}
+		return 0
+	})
+	var after rpc.RequestInfo
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		after = *i
+	})
+	expectError(t, call(s, "FailService.Fail", []HelloArgs{{}}), http.StatusOK, "failed")
+	if seen.Method != "FailService.Fail" || seen.StatusCode != http.StatusBadRequest {
+		t.Errorf("status func method = %q, status = %d", seen.Method, seen.StatusCode)
+	}
+	if after.StatusCode != http.StatusOK {
+		t.Errorf("after func status = %d, want %d", after.StatusCode, http.StatusOK)
+	}
+	// Zero keeps the status.
+	expectResult(t, call(s, "HelloService.Say", []HelloArgs{{Who: "a"}}), `{"Message":"Hello, a!"}`)
+	// Requests rejected before their method is resolved are not remapped.
+	r := serveRequest("POST", "/rpc", `{}`)
+	r.Header.Set("Content-Type", "text/xml")
+	seen = rpc.RequestInfo{}
+	expect(t, serveHTTP(s, r), http.StatusUnsupportedMediaType)
+	if seen.StatusCode != 0 {
+		t.Errorf("status func called for status %d", seen.StatusCode)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// ReplaceService
+// ----------------------------------------------------------------------------
+
+type VersionService struct {
+	version string
+}
+
+func (s *VersionService) Get(r *http.Request, args *HelloArgs, reply *HelloReply) error {
+	reply.Message = s.version
+	return nil
+}
+
+func TestReplaceService(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(&VersionService{version: "v1"}, "Version"); err != nil {
+		t.Fatal(err)
+	}
+	expectResult(t, call(s, "Version.Get", []HelloArgs{{}}), `{"Message":"v1"}`)
+	if err := s.ReplaceService("Version", &VersionService{version: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	expectResult(t, call(s, "Version.Get", []HelloArgs{{}}), `{"Message":"v2"}`)
+	// The stats are kept.
+	if calls := s.Stats()["Version.Get"].Calls; calls != 2 {
+		t.Errorf("calls = %d, want 2", calls)
+	}
+
+	if err := s.ReplaceService("Unknown", &VersionService{}); err == nil {
+		t.Error("unknown service replaced")
+	}
+	if err := s.ReplaceService("rpc", &VersionService{}); err == nil {
+		t.Error("reserved service replaced")
+	}
+	if err := rpc.Method(s, "Funcs.Get", (&VersionService{}).Get); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReplaceService("Funcs", &VersionService{}); err == nil {
+		t.Error("function service replaced")
+	}
+}
+
+func TestReplaceServiceConcurrent(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(&VersionService{version: "v0"}, "Version"); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	failures := make(chan string, 100)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				w := call(s, "Version.Get", []HelloArgs{{}})
+				if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"Message":"v`) {
+					failures <- w.Body.String()
+					return
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 50; i++ {
+		if err := s.ReplaceService("Version", &VersionService{version: fmt.Sprintf("v%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	close(failures)
+	for body := range failures {
+		t.Errorf("call failed during a replacement: %s", body)
+	}
+	expectResult(t, call(s, "Version.Get", []HelloArgs{{}}), `{"Message":"v50"}`)
+}
+
+func TestLogFields(t *testing.T) {
+	s := newServer(t)
+	var calls int
+	s.RegisterLogFieldsFunc(func(i *rpc.RequestInfo) map[string]interface{} {
+		calls++
+		return map[string]interface{}{"tenant": i.Request.Header.Get("X-Tenant"), "method": i.Method}
+	})
+	var before, validate, after map[string]interface{}
+	s.RegisterBeforeFunc(func(i *rpc.RequestInfo) {
+		before = i.LogFields
+	})
+	s.RegisterValidateRequestFunc(func(i *rpc.RequestInfo, args interface{}) error {
+		validate = i.LogFields
+		return nil
+	})
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		after = i.LogFields
+	})
+	r := serveRequest("POST", "/rpc", `{"method":"HelloService.Say","params":[{"Who":"a"}]}`)
+	r.Header.Set("X-Tenant", "acme")
+	expect(t, serveHTTP(s, r), http.StatusOK)
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+	want := map[string]interface{}{"tenant": "acme", "method": "HelloService.Say"}
+	for hook, fields := range map[string]map[string]interface{}{"before": before, "validate": validate, "after": after} {
+		if fmt.Sprint(fields) != fmt.Sprint(want) {
+			t.Errorf("%s fields = %v, want %v", hook, fields, want)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// TrimStringParams
+// ----------------------------------------------------------------------------
+
+type AddressArgs struct {
+	City string
+}
+
+type ProfileArgs struct {
+	Name    string
+	Tags    []string
+	Address *AddressArgs
+	Home    AddressArgs
+}
+
+type ProfileService struct{}
+
+func (s *ProfileService) Echo(r *http.Request, args *ProfileArgs, reply *ProfileArgs) error {
+	*reply = *args
+	return nil
+}
+
+func TestTrimStringParams(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(ProfileService), ""); err != nil {
+		t.Fatal(err)
+	}
+	params := ProfileArgs{Name: " a ", Tags: []string{" b "}, Address: &AddressArgs{City: "\tc\n"}, Home: AddressArgs{City: " d"}}
+	expectResult(t, call(s, "ProfileService.Echo", params),
+		`{"Name":" a ","Tags":[" b "],"Address":{"City":"\tc\n"},"Home":{"City":" d"}}`)
+	s.TrimStringParams()
+	expectResult(t, call(s, "ProfileService.Echo", params),
+		`{"Name":"a","Tags":[" b "],"Address":{"City":"c"},"Home":{"City":"d"}}`)
+}
+
+// ----------------------------------------------------------------------------
+// RegisterServiceFactory
+// ----------------------------------------------------------------------------
+
+type SessionService struct {
+	user  string
+	calls int
+}
+
+func (s *SessionService) Whoami(r *http.Request, args *HelloArgs, reply *HelloReply) error {
+	s.calls++
+	reply.Message = fmt.Sprintf("%s %d", s.user, s.calls)
+	return nil
+}
+
+func TestRegisterServiceFactory(t *testing.T) {
+	s := newServer(t)
+	var created int
+	err := s.RegisterServiceFactory("", new(SessionService), func(r *http.Request) interface{} {
+		created++
+		return &SessionService{user: r.Header.Get("X-User")}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 0 {
+		t.Errorf("created = %d at registration, want 0", created)
+	}
+	for _, user := range []string{"alice", "bob"} {
+		r := serveRequest("POST", "/rpc", `{"method":"SessionService.Whoami","params":[{}]}`)
+		r.Header.Set("X-User", user)
+		// Every request gets a new receiver.
+		expectResult(t, serveHTTP(s, r), `{"Message":"`+user+` 1"}`)
+	}
+	if created != 2 {
+		t.Errorf("created = %d, want 2", created)
+	}
+	if err := s.ReplaceService("SessionService", new(SessionService)); err == nil {
+		t.Error("factory service replaced")
+	}
+}
+
+func TestRegisterServiceFactoryType(t *testing.T) {
+	s := newServer(t)
+	err := s.RegisterServiceFactory("", new(SessionService), func(r *http.Request) interface{} {
+		return new(HelloService)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := call(s, "SessionService.Whoami", []HelloArgs{{}})
+	expectError(t, w, http.StatusInternalServerError, "returned a receiver of another type")
+	if err := s.RegisterServiceFactory("", nil, func(r *http.Request) interface{} {
+		return new(SessionService)
+	}); err == nil {
+		t.Error("factory registered without prototype")
+	}
+}
+
+// ----------------------------------------------------------------------------
+// ErrNotModified
+// ----------------------------------------------------------------------------
+
+type CacheArgs struct {
+	Version int
+}
+
+type CacheService struct{}
+
+func (s *CacheService) Get(r *http.Request, args *CacheArgs, reply *HelloReply) error {
+	if args.Version == 2 {
+		return fmt.Errorf("cache: %w", rpc.ErrNotModified)
+	}
+	reply.Message = "v2"
+	return nil
+}
+
+func TestNotModified(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(CacheService), ""); err != nil {
+		t.Fatal(err)
+	}
+	var info rpc.RequestInfo
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		info = *i
+	})
+	expectResult(t, call(s, "CacheService.Get", []CacheArgs{{Version: 1}}), `{"Message":"v2"}`)
+	w := call(s, "CacheService.Get", []CacheArgs{{Version: 2}})
+	expect(t, w, http.StatusNotModified)
+	if w.Body.Len() != 0 {
+		t.Errorf("body = %q, want none", w.Body.String())
+	}
+	if info.StatusCode != http.StatusNotModified || info.Error != nil {
+		t.Errorf("after func status = %d, error = %v", info.StatusCode, info.Error)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Panics
+// ----------------------------------------------------------------------------
+
+// InputError is a panic value caused by invalid input.
+type InputError struct {
+	Field string
+}
+
+type PanicArgs struct {
+	Kind string
+}
+
+type PanicService struct{}
+
+func (s *PanicService) Do(r *http.Request, args *PanicArgs, reply *HelloReply) error {
+	switch args.Kind {
+	case "input":
+		panic(InputError{Field: "Kind"})
+	case "reply":
+		panic("recover with a reply")
+	case "other":
+		panic("unexpected")
+	}
+	reply.Message = "done"
+	return nil
+}
+
+func TestPanicHandler(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(PanicService), ""); err != nil {
+		t.Fatal(err)
+	}
+	var recovered []interface{}
+	s.RegisterPanicHandler(func(i *rpc.RequestInfo, p interface{}) (int, interface{}) {
+		recovered = append(recovered, p)
+		if e, ok := p.(InputError); ok {
+			return http.StatusBadRequest, fmt.Errorf("invalid %s", e.Field)
+		}
+		if p == "recover with a reply" {
+			return http.StatusAccepted, &HelloReply{Message: "recovered"}
+		}
+		return 0, nil
+	})
+	expectError(t, call(s, "PanicService.Do", []PanicArgs{{Kind: "input"}}), http.StatusBadRequest, "invalid Kind")
+	w := call(s, "PanicService.Do", []PanicArgs{{Kind: "reply"}})
+	expect(t, w, http.StatusAccepted)
+	if res := decode(t, w); !jsonEqual(res.Result, `{"Message":"recovered"}`) {
+		t.Errorf("result = %s", res.Result)
+	}
+	expectError(t, call(s, "PanicService.Do", []PanicArgs{{Kind: "other"}}), http.StatusInternalServerError, "method panicked")
+	expectResult(t, call(s, "PanicService.Do", []PanicArgs{{}}), `{"Message":"done"}`)
+
+	if len(recovered) != 3 {
+		t.Errorf("recovered = %v, want 3 panics", recovered)
+	}
+	if stats := s.Stats()["PanicService.Do"]; stats.Calls != 4 || stats.Errors != 3 {
+		t.Errorf("stats = %+v, want 4 calls and 3 errors", stats)
+	}
+}
+
+func TestPanicWithoutHandler(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(PanicService), ""); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if p := recover(); p != "unexpected" {
+			t.Errorf("recovered %v, want the method panic", p)
+		}
+	}()
+	call(s, "PanicService.Do", []PanicArgs{{Kind: "other"}})
+	t.Error("panic recovered without a panic handler")
+}
+
+// ----------------------------------------------------------------------------
+// Case policy
+// ----------------------------------------------------------------------------
+
+func TestCasePolicy(t *testing.T) {
+	tests := []struct {
+		policy rpc.CasePolicy
+		method string
+		status int
+	}{
+		{rpc.CaseExact, "HelloService.Say", http.StatusOK},
+		{rpc.CaseExact, "helloService.say", http.StatusBadRequest},
+		{rpc.CaseTitleFirst, "HelloService.Say", http.StatusOK},
+		{rpc.CaseTitleFirst, "helloService.say", http.StatusOK},
+		{rpc.CaseTitleFirst, "helloservice.say", http.StatusBadRequest},
+		{rpc.CaseLowerFirst, "helloService.say", http.StatusOK},
+		{rpc.CaseLowerFirst, "HelloService.Say", http.StatusBadRequest},
+		{rpc.CaseLowerFirst, "helloService.Say", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		s := newServer(t)
+		s.SetCasePolicy(test.policy)
+		var info rpc.RequestInfo
+		s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+			info = *i
+		})
+		w := call(s, test.method, []HelloArgs{{Who: "a"}})
+		if w.Code != test.status {
+			t.Errorf("policy %d, method %q: status = %d, want %d", test.policy, test.method, w.Code, test.status)
+			continue
+		}
+		if test.status == http.StatusOK && (info.Method != "HelloService.Say" || info.RawMethod != test.method) {
+			t.Errorf("policy %d: method = %q, raw method = %q", test.policy, info.Method, info.RawMethod)
+		}
+	}
+}
+
+func TestCasePolicyNotFound(t *testing.T) {
+	s := newServer(t)
+	s.SetCasePolicy(rpc.CaseLowerFirst)
+	w := call(s, "helloService.Say", []HelloArgs{{}})
+	expectError(t, w, http.StatusBadRequest, "method not found")
+	w = call(s, "HelloService.say", []HelloArgs{{}})
+	expectError(t, w, http.StatusBadRequest, "service not found")
+}
+
+// ----------------------------------------------------------------------------
+// Query param methods
+// ----------------------------------------------------------------------------
+
+func TestQueryParamMethods(t *testing.T) {
+	s := newServer(t)
+	query := "/rpc?method=HelloService.Say&Who=q"
+	body := `{"method":"HelloService.Say","params":{"Who":"b"}}`
+
+	// By default, GET is rejected and the other verbs read the body.
+	expectError(t, serve(s, "GET", query, ""), http.StatusMethodNotAllowed, "GET method not allowed")
+	for _, verb := range []string{"POST", "PUT", "PATCH", "DELETE"} {
+		expectResult(t, serve(s, verb, query, body), `{"Message":"Hello, b!"}`)
+	}
+
+	s.SetQueryParamMethods("get", "DELETE")
+	expectResult(t, serve(s, "GET", query, ""), `{"Message":"Hello, q!"}`)
+	expectResult(t, serve(s, "DELETE", query, body), `{"Message":"Hello, q!"}`)
+	expectResult(t, serve(s, "PUT", query, body), `{"Message":"Hello, b!"}`)
+
+	// GET is disabled again when left out.
+	s.SetQueryParamMethods("DELETE")
+	expect(t, serve(s, "GET", query, ""), http.StatusMethodNotAllowed)
+}
+
+// ----------------------------------------------------------------------------
+// Retryable errors
+// ----------------------------------------------------------------------------
+
+type retryError struct {
+	retryable bool
+}
+
+func (e retryError) Error() string   { return fmt.Sprintf("retryable: %t", e.retryable) }
+func (e retryError) Retryable() bool { return e.retryable }
+
+type RetryArgs struct {
+	Retryable bool
+}
+
+type RetryService struct{}
+
+func (s *RetryService) Do(r *http.Request, args *RetryArgs, reply *HelloReply) error {
+	return fmt.Errorf("do: %w", retryError{retryable: args.Retryable})
+}
+
+func TestRetryableError(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(RetryService), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterService(new(FailService), ""); err != nil {
+		t.Fatal(err)
+	}
+	w := call(s, "RetryService.Do", []RetryArgs{{Retryable: true}})
+	expect(t, w, http.StatusServiceUnavailable)
+	if header := w.Header().Get("X-RPC-Retryable"); header != "true" {
+		t.Errorf("X-RPC-Retryable = %q", header)
+	}
+	if res := decode(t, w); !jsonEqual(res.Error, `{"message":"do: retryable: true","retryable":true}`) {
+		t.Errorf("error = %s", res.Error)
+	}
+
+	w = call(s, "RetryService.Do", []RetryArgs{{Retryable: false}})
+	expect(t, w, http.StatusBadRequest)
+	if header := w.Header().Get("X-RPC-Retryable"); header != "false" {
+		t.Errorf("X-RPC-Retryable = %q", header)
+	}
+
+	// Other errors leave the header out.
+	w = call(s, "FailService.Fail", []HelloArgs{{}})
+	if header := w.Header().Get("X-RPC-Retryable"); header != "" {
+		t.Errorf("X-RPC-Retryable = %q, want none", header)
+	}
+}
+
+func TestMethodNameMissing(t *testing.T) {
+	s := newServer(t)
+	s.SetQueryParamMethods("GET")
+	expectError(t, serve(s, "GET", "/rpc?Who=a", ""), http.StatusBadRequest, "rpc: method name missing")
+	expectError(t, serve(s, "POST", "/rpc", `{"params":{"Who":"a"}}`), http.StatusBadRequest, "rpc: method name missing")
+	expectError(t, serve(s, "POST", "/rpc", `{"method":"","params":{"Who":"a"}}`), http.StatusBadRequest, "rpc: method name missing")
+}
+
+func TestRequestInfoArgs(t *testing.T) {
+	s := newServer(t)
+	var before, validated, after interface{}
+	s.RegisterBeforeFunc(func(i *rpc.RequestInfo) {
+		before = i.Args
+	})
+	s.RegisterValidateRequestFunc(func(i *rpc.RequestInfo, args interface{}) error {
+		validated = i.Args
+		return nil
+	})
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		after = i.Args
+	})
+	expect(t, call(s, "HelloService.Say", []HelloArgs{{Who: "a"}}), http.StatusOK)
+	// The before func runs once the args are decoded.
+	for hook, args := range map[string]interface{}{"before": before, "validate": validated, "after": after} {
+		if hello, ok := args.(*HelloArgs); !ok || hello.Who != "a" {
+			t.Errorf("%s func args = %#v, want &HelloArgs{Who: \"a\"}", hook, args)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// context.Context methods
+// ----------------------------------------------------------------------------
+
+type ctxKey struct{}
+
+type ContextService struct{}
+
+func (s *ContextService) Value(ctx context.Context, args *HelloArgs, reply *HelloReply) error {
+	reply.Message, _ = ctx.Value(ctxKey{}).(string)
+	return nil
+}
+
+func (s *ContextService) Request(r *http.Request, args *HelloArgs, reply *HelloReply) error {
+	reply.Message, _ = r.Context().Value(ctxKey{}).(string)
+	return nil
+}
+
+func TestContextMethods(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(ContextService), ""); err != nil {
+		t.Fatal(err)
+	}
+	if !s.HasMethod("ContextService.Value") {
+		t.Fatal("method taking a context.Context not registered")
+	}
+	s.RegisterInterceptFunc(func(i *rpc.RequestInfo) *http.Request {
+		return i.Request.WithContext(context.WithValue(i.Request.Context(), ctxKey{}, "intercepted"))
+	})
+	for _, method := range []string{"ContextService.Value", "ContextService.Request"} {
+		expectResult(t, call(s, method, []HelloArgs{{}}), `{"Message":"intercepted"}`)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Methods without args
+// ----------------------------------------------------------------------------
+
+type PingService struct{}
+
+func (s *PingService) Ping(r *http.Request, reply *HelloReply) error {
+	reply.Message = "pong"
+	return nil
+}
+
+func (s *PingService) Echo(r *http.Request, args *HelloArgs, reply *HelloReply) error {
+	reply.Message = args.Who
+	return nil
+}
+
+func TestMethodsWithoutArgs(t *testing.T) {
+	s := newServer(t)
+	type registered struct {
+		method string
+		noArgs bool
+	}
+	var methods []registered
+	s.OnMethodRegistered(func(service, method string, noArgs bool) {
+		methods = append(methods, registered{service + "." + method, noArgs})
+	})
+	// Methods without args are skipped by default.
+	if err := s.RegisterService(new(PingService), ""); err != nil {
+		t.Fatal(err)
+	}
+	if s.HasMethod("PingService.Ping") {
+		t.Error("PingService.Ping registered without AllowMethodsWithoutArgs")
+	}
+	if want := []registered{{"PingService.Echo", false}}; !reflect.DeepEqual(methods, want) {
+		t.Errorf("registered = %v, want %v", methods, want)
+	}
+
+	s = newServer(t)
+	s.AllowMethodsWithoutArgs()
+	methods = nil
+	s.OnMethodRegistered(func(service, method string, noArgs bool) {
+		methods = append(methods, registered{service + "." + method, noArgs})
+	})
+	if err := s.RegisterService(new(PingService), ""); err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].method < methods[j].method })
+	if want := []registered{{"PingService.Echo", false}, {"PingService.Ping", true}}; !reflect.DeepEqual(methods, want) {
+		t.Errorf("registered = %v, want %v", methods, want)
+	}
+	// The params are ignored.
+	expectResult(t, call(s, "PingService.Ping", nil), `{"Message":"pong"}`)
+	expectResult(t, call(s, "PingService.Ping", []HelloArgs{{Who: "a"}}), `{"Message":"pong"}`)
+
+	var args interface{} = "unset"
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		args = i.Args
+	})
+	call(s, "PingService.Ping", nil)
+	if args != nil {
+		t.Errorf("args = %#v, want nil", args)
+	}
+}
+
+func TestRawMethod(t *testing.T) {
+	s := newServer(t)
+	s.SetCasePolicy(rpc.CaseTitleFirst)
+	s.SetMethodResolver(func(r *http.Request, rawMethod string) (string, error) {
+		// The case policy applies first.
+		if rawMethod == "Hello.Say" {
+			return "HelloService.Say", nil
+		}
+		return rawMethod, nil
+	})
+	var info rpc.RequestInfo
+	s.RegisterBeforeFunc(func(i *rpc.RequestInfo) {
+		info = *i
+	})
+	w := call(s, "hello.say", []HelloArgs{{Who: "a"}})
+	expectResult(t, w, `{"Message":"Hello, a!"}`)
+	if info.Method != "HelloService.Say" || info.RawMethod != "hello.say" {
+		t.Errorf("method = %q, raw method = %q", info.Method, info.RawMethod)
+	}
+
+	// Unknown methods are reported as sent.
+	s.SetMethodResolver(nil)
+	w = call(s, "helloService.shout", []HelloArgs{{}})
+	expect(t, w, http.StatusBadRequest)
+	if res := decode(t, w); !jsonEqual(res.Error, `{"message":"method not found","service":"helloService","method":"shout"}`) {
+		t.Errorf("error = %s", res.Error)
+	}
+}
+
+func TestValidateWithoutArgs(t *testing.T) {
+	s := newServer(t)
+	s.AllowMethodsWithoutArgs()
+	if err := s.RegisterService(new(PingService), ""); err != nil {
+		t.Fatal(err)
+	}
+	var validated interface{} = "unset"
+	s.RegisterValidateRequestFunc(func(i *rpc.RequestInfo, args interface{}) error {
+		validated = args
+		if i.Request.Header.Get("Authorization") == "" {
+			return fmt.Errorf("unauthorized")
+		}
+		return nil
+	})
+	expectError(t, call(s, "PingService.Ping", nil), http.StatusBadRequest, "unauthorized")
+	if validated != nil {
+		t.Errorf("validated args = %#v, want nil", validated)
+	}
+	r := serveRequest("POST", "/rpc", `{"method":"PingService.Ping"}`)
+	r.Header.Set("Authorization", "Bearer token")
+	expectResult(t, serveHTTP(s, r), `{"Message":"pong"}`)
+}
+
+type IdsArgs struct {
+	Id []int
+}
+
+type IdsService struct{}
+
+func (s *IdsService) Count(r *http.Request, args *IdsArgs, reply *int) error {
+	*reply = len(args.Id)
+	return nil
+}
+
+func TestMaxValuesPerParam(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(IdsService), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.SetQueryParamMethods("GET")
+	url := "/rpc?method=IdsService.Count&id=1&id=2&id=3"
+	expectResult(t, serve(s, "GET", url, ""), `3`)
+
+	s.SetMaxValuesPerParam(2)
+	expectError(t, serve(s, "GET", url, ""), http.StatusBadRequest, `too many values for query parameter "id"`)
+	expectResult(t, serve(s, "GET", "/rpc?method=IdsService.Count&id=1&id=2", ""), `2`)
+	// Requests reading the body are not limited.
+	expectResult(t, serve(s, "POST", url, `{"method":"IdsService.Count","params":{"Id":[1,2,3]}}`), `3`)
+}
+
+// ----------------------------------------------------------------------------
+// Function maps
+// ----------------------------------------------------------------------------
+
+type MathArgs struct {
+	A int
+}
+
+func TestRegisterFuncMap(t *testing.T) {
+	s := newServer(t)
+	err := s.RegisterFuncMap("Math", map[string]interface{}{
+		"Double": func(r *http.Request, args *MathArgs, reply *int) error {
+			*reply = 2 * args.A
+			return nil
+		},
+		"Name": func(ctx context.Context, args *HelloArgs, reply *HelloReply) error {
+			reply.Message = "math " + args.Who
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectResult(t, call(s, "Math.Double", []MathArgs{{A: 21}}), `42`)
+	expectResult(t, call(s, "Math.Name", []HelloArgs{{Who: "a"}}), `{"Message":"math a"}`)
+	expectError(t, call(s, "Math.Triple", []MathArgs{{}}), http.StatusBadRequest, "method not found")
+
+	// Valid entries are registered along with invalid ones.
+	err = s.RegisterFuncMap("Math", map[string]interface{}{
+		"Half":   func(r *http.Request, args *MathArgs, reply *int) error { *reply = args.A / 2; return nil },
+		"Bad":    func(args *MathArgs) error { return nil },
+		"NotFn":  42,
+		"Double": func(r *http.Request, args *MathArgs, reply *int) error { return nil },
+	})
+	if err == nil {
+		t.Fatal("invalid entries registered without error")
+	}
+	for _, want := range []string{`"Bad"`, `"NotFn"`, `"Math.Double"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+	expectResult(t, call(s, "Math.Half", []MathArgs{{A: 8}}), `4`)
+
+	// Namespaces of services registered with RegisterService are taken.
+	err = s.RegisterFuncMap("HelloService", map[string]interface{}{
+		"Other": func(r *http.Request, args *HelloArgs, reply *HelloReply) error { return nil },
+	})
+	if err == nil {
+		t.Error("function registered in the namespace of a service")
+	}
+	if err := s.RegisterFuncMap("", nil); err == nil {
+		t.Error("function map registered without a namespace")
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Responses without body
+// ----------------------------------------------------------------------------
+
+// expectNoBody checks the status, the X-RPC-Error header and the empty body
+// of a response without body.
+func expectNoBody(t *testing.T, w *httptest.ResponseRecorder, status int, rpcError string) {
+	t.Helper()
+	if w.Code != status {
+		t.Errorf("status = %d, want %d", w.Code, status)
+	}
+	if h := w.Header().Get("X-RPC-Error"); !strings.Contains(h, rpcError) || (rpcError == "") != (h == "") {
+		t.Errorf("X-RPC-Error = %q, want %q", h, rpcError)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("body = %q, want empty", w.Body.String())
+	}
+}
+
+func TestHEAD(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(FailService), ""); err != nil {
+		t.Fatal(err)
+	}
+	expectNoBody(t, serve(s, "HEAD", "/rpc?method=HelloService.Say&who=a", ""), http.StatusMethodNotAllowed, "HEAD method not allowed")
+
+	s.SetQueryParamMethods("GET")
+	w := serve(s, "HEAD", "/rpc?method=HelloService.Say&who=a", "")
+	expectNoBody(t, w, http.StatusOK, "")
+	if ct := w.Header().Get("Content-Type"); ct == "" {
+		t.Error("Content-Type of the GET response not kept")
+	}
+	expectNoBody(t, serve(s, "HEAD", "/rpc?method=FailService.Fail", ""), http.StatusBadRequest, "failed")
+	expectNoBody(t, serve(s, "HEAD", "/rpc?method=FailService.Other", ""), http.StatusBadRequest, "can't find method")
+}
+
+func TestSetNoContentMethods(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(FailService), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.SetNoContentMethods("delete")
+	w := serve(s, "DELETE", "/rpc", `{"method":"HelloService.Say","params":[{"Who":"a"}]}`)
+	expectNoBody(t, w, http.StatusNoContent, "")
+	if ct := w.Header().Get("Content-Type"); ct != "" {
+		t.Errorf("Content-Type = %q, want none", ct)
+	}
+	expectNoBody(t, serve(s, "DELETE", "/rpc", `{"method":"FailService.Fail","params":[{}]}`), http.StatusBadRequest, "failed")
+	// Other HTTP methods keep their body.
+	expectResult(t, call(s, "HelloService.Say", []HelloArgs{{Who: "a"}}), `{"Message":"Hello, a!"}`)
+}
+
+// ----------------------------------------------------------------------------
+// Args guard
+// ----------------------------------------------------------------------------
+
+type GuardedService struct {
+	calls int
+}
+
+func (s *GuardedService) Say(r *http.Request, args *HelloArgs, reply *HelloReply) error {
+	s.calls++
+	reply.Message = "Hello, " + args.Who + "!"
+	return nil
+}
+
+func TestRegisterArgsGuard(t *testing.T) {
+	s := newServer(t)
+	guarded := new(GuardedService)
+	if err := s.RegisterService(guarded, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterValidateRequestFunc(func(i *rpc.RequestInfo, args interface{}) error {
+		if args.(*HelloArgs).Who == "" {
+			return errors.New("Who is required")
+		}
+		return nil
+	})
+	var guardCalls int
+	s.RegisterArgsGuard(func(i *rpc.RequestInfo, args interface{}) (int, interface{}, bool) {
+		guardCalls++
+		switch args.(*HelloArgs).Who {
+		case "blocked":
+			return 0, nil, true
+		case "teapot":
+			return http.StatusTeapot, errors.New("short and stout"), true
+		case "cached":
+			return http.StatusOK, &HelloReply{Message: "from the guard"}, true
+		}
+		return 0, nil, false
+	})
+	expectResult(t, call(s, "GuardedService.Say", []HelloArgs{{Who: "a"}}), `{"Message":"Hello, a!"}`)
+	expectError(t, call(s, "GuardedService.Say", []HelloArgs{{Who: "blocked"}}), http.StatusForbidden, "request rejected")
+	expectError(t, call(s, "GuardedService.Say", []HelloArgs{{Who: "teapot"}}), http.StatusTeapot, "short and stout")
+	expectResult(t, call(s, "GuardedService.Say", []HelloArgs{{Who: "cached"}}), `{"Message":"from the guard"}`)
+	if guarded.calls != 1 {
+		t.Errorf("method called %d times, want 1", guarded.calls)
+	}
+	// Requests failing validation don't reach the guard.
+	expectError(t, call(s, "GuardedService.Say", []HelloArgs{{}}), http.StatusBadRequest, "Who is required")
+	if guardCalls != 4 {
+		t.Errorf("guard called %d times, want 4", guardCalls)
+	}
+}
+
+func TestRegisterArgsGuardWithoutArgs(t *testing.T) {
+	s := newServer(t)
+	s.AllowMethodsWithoutArgs()
+	if err := s.RegisterService(new(PingService), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterArgsGuard(func(i *rpc.RequestInfo, args interface{}) (int, interface{}, bool) {
+		return 0, nil, args != nil
+	})
+	expectResult(t, call(s, "PingService.Ping", nil), `{"Message":"pong"}`)
+	expectError(t, call(s, "PingService.Echo", []HelloArgs{{Who: "a"}}), http.StatusForbidden, "request rejected")
+}
@@ -0,0 +1,134 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// introspectionService is the name under which the built-in introspection
+// methods are registered. It is reserved and cannot be used by services.
+const introspectionService = "rpc"
+
+// MethodStats holds the invocation statistics of a method.
+type MethodStats struct {
+	// Calls is the number of times the method was invoked.
+	Calls uint64
+	// Errors is the number of invocations that returned an error.
+	Errors uint64
+	// TotalDuration is the accumulated time spent serving the method.
+	TotalDuration time.Duration
+	// RequestBytes is the accumulated size of the request bodies read.
+	RequestBytes uint64
+	// ResponseBytes is the accumulated size of the response bodies written.
+	ResponseBytes uint64
+}
+
+// methodStats holds the counters of a method. They are updated atomically.
+type methodStats struct {
+	calls         uint64
+	errors        uint64
+	duration      int64
+	requestBytes  uint64
+	responseBytes uint64
+}
+
+// record adds an invocation to the counters, along with the sizes of its
+// request and response bodies.
+func (m *methodStats) record(d time.Duration, failed bool, body *countingReader, out *countingResponse) {
+	atomic.AddUint64(&m.calls, 1)
+	if failed {
+		atomic.AddUint64(&m.errors, 1)
+	}
+	atomic.AddInt64(&m.duration, int64(d))
+	atomic.AddUint64(&m.requestBytes, body.n)
+	atomic.AddUint64(&m.responseBytes, out.n)
+}
+
+// snapshot returns the current value of the counters.
+func (m *methodStats) snapshot() MethodStats {
+	return MethodStats{
+		Calls:         atomic.LoadUint64(&m.calls),
+		Errors:        atomic.LoadUint64(&m.errors),
+		TotalDuration: time.Duration(atomic.LoadInt64(&m.duration)),
+		RequestBytes:  atomic.LoadUint64(&m.requestBytes),
+		ResponseBytes: atomic.LoadUint64(&m.responseBytes),
+	}
+}
+
+// MethodExample holds example payloads of a method, for documentation.
+type MethodExample struct {
+	// Request is an example of the method args.
+	Request interface{} `json:"request"`
+	// Response is an example of the method reply.
+	Response interface{} `json:"response"`
+}
+
+// introspection implements the built-in methods of the reserved "rpc"
+// service.
+type introspection struct {
+	server *Server
+}
+
+// Stats returns the invocation statistics of the registered methods.
+func (i *introspection) Stats(r *http.Request, args *struct{}, reply *map[string]MethodStats) error {
+	*reply = i.server.Stats()
+	return nil
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n uint64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += uint64(n)
+	return n, err
+}
+
+// countingResponse counts the bytes of a response body. It also records
+// the status written.
+type countingResponse struct {
+	http.ResponseWriter
+	n      uint64
+	status int
+}
+
+func (c *countingResponse) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *countingResponse) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	n, err := c.ResponseWriter.Write(p)
+	c.n += uint64(n)
+	return n, err
+}
+
+// Flush flushes the wrapped ResponseWriter, if supported, for streamed
+// replies.
+func (c *countingResponse) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Examples returns the examples attached to the registered methods with
+// Server.SetMethodExample.
+func (i *introspection) Examples(r *http.Request, args *struct{}, reply *map[string]MethodExample) error {
+	*reply = i.server.services.examples()
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/shridarpatil/rpc"
+)
+
+type FailService struct{}
+
+func (s *FailService) Fail(r *http.Request, args *HelloArgs, reply *HelloReply) error {
+	return errors.New("failed")
+}
+
+func TestStats(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(FailService), ""); err != nil {
+		t.Fatal(err)
+	}
+	call(s, "HelloService.Say", []HelloArgs{{Who: "a"}})
+	call(s, "HelloService.Say", []HelloArgs{{Who: "b"}})
+	call(s, "FailService.Fail", []HelloArgs{{}})
+
+	stats := s.Stats()
+	if say := stats["HelloService.Say"]; say.Calls != 2 || say.Errors != 0 {
+		t.Errorf("HelloService.Say stats = %+v, want 2 calls and no errors", say)
+	}
+	if fail := stats["FailService.Fail"]; fail.Calls != 1 || fail.Errors != 1 {
+		t.Errorf("FailService.Fail stats = %+v, want 1 call and 1 error", fail)
+	}
+}
+
+func TestStatsConcurrent(t *testing.T) {
+	s := newServer(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			call(s, "HelloService.Say", []HelloArgs{{Who: "a"}})
+		}()
+	}
+	wg.Wait()
+	if calls := s.Stats()["HelloService.Say"].Calls; calls != 50 {
+		t.Errorf("calls = %d, want 50", calls)
+	}
+}
+
+func TestIntrospection(t *testing.T) {
+	s := newServer(t)
+	call(s, "HelloService.Say", []HelloArgs{{Who: "a"}})
+
+	// The introspection service is opt-in.
+	w := call(s, "rpc.Stats", []struct{}{{}})
+	if w.Code == http.StatusOK {
+		t.Fatalf("rpc.Stats served before EnableIntrospection: %s", w.Body.String())
+	}
+
+	s.EnableIntrospection()
+	s.EnableIntrospection()
+	w = call(s, "rpc.Stats", []struct{}{{}})
+	expect(t, w, http.StatusOK)
+	var stats map[string]rpc.MethodStats
+	if err := json.Unmarshal(decode(t, w).Result, &stats); err != nil {
+		t.Fatal(err)
+	}
+	if calls := stats["HelloService.Say"].Calls; calls != 1 {
+		t.Errorf("HelloService.Say calls = %d, want 1", calls)
+	}
+}
+
+func TestIntrospectionReservedName(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(HelloService), "rpc"); err == nil {
+		t.Error("service registered under the reserved name")
+	}
+	err := s.RegisterFuncMap("rpc", map[string]interface{}{
+		"Say": func(r *http.Request, args *HelloArgs, reply *HelloReply) error { return nil },
+	})
+	if err == nil {
+		t.Error("function registered under the reserved name")
+	}
+	s.EnableIntrospection()
+	if err := s.RegisterService(new(HelloService), "rpc"); err == nil {
+		t.Error("service registered under the reserved name once enabled")
+	}
+}
+
+func TestStatsBodySizes(t *testing.T) {
+	s := newServer(t)
+	body := `{"method":"HelloService.Say","params":[{"Who":"gopher"}]}`
+	w := serve(s, "POST", "/rpc", body)
+	expect(t, w, http.StatusOK)
+	stats := s.Stats()["HelloService.Say"]
+	if stats.RequestBytes != uint64(len(body)) {
+		t.Errorf("request bytes = %d, want %d", stats.RequestBytes, len(body))
+	}
+	if stats.ResponseBytes != uint64(w.Body.Len()) {
+		t.Errorf("response bytes = %d, want %d", stats.ResponseBytes, w.Body.Len())
+	}
+	serve(s, "POST", "/rpc", body)
+	if total := s.Stats()["HelloService.Say"].RequestBytes; total != uint64(2*len(body)) {
+		t.Errorf("request bytes = %d, want %d", total, 2*len(body))
+	}
+}
+
+func TestMethodExample(t *testing.T) {
+	s := newServer(t)
+	s.EnableIntrospection()
+	if err := s.SetMethodExample("HelloService.Missing", HelloArgs{}, HelloReply{}); err == nil {
+		t.Error("example set for an unknown method")
+	}
+	if err := s.SetMethodExample("HelloService.Say", HelloArgs{Who: "a"}, HelloReply{Message: "Hello, a!"}); err != nil {
+		t.Fatal(err)
+	}
+	w := call(s, "rpc.Examples", []struct{}{{}})
+	expectResult(t, w, `{"HelloService.Say":{"request":{"Who":"a"},"response":{"Message":"Hello, a!"}}}`)
+}
@@ -0,0 +1,96 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+// StreamWriter is implemented by a CodecRequest able to write the replies of
+// streaming methods, e.g. as newline-delimited values.
+//
+// A streaming method takes a channel or a callback in place of the reply
+// pointer, as in:
+//
+//	func (t *T) Sync(r *http.Request, args *Args, replies chan<- *Reply) error
+//	func (t *T) Sync(r *http.Request, args *Args, send func(*Reply)) error
+//
+// Every reply sent on the channel or passed to the callback is written and
+// flushed right away. The server closes the channel once the method returns,
+// so the method must not close it nor send on it afterwards.
+type StreamWriter interface {
+	// WriteStreamReply writes a single reply of the stream.
+	WriteStreamReply(w http.ResponseWriter, reply interface{}) error
+	// WriteStreamError ends a stream with the error returned by the method.
+	WriteStreamError(w http.ResponseWriter, err error)
+}
+
+// replyStream writes the replies of a streaming method as they are produced.
+type replyStream struct {
+	w      http.ResponseWriter
+	writer StreamWriter
+	mutex  sync.Mutex
+	count  int   // replies written
+	err    error // first write error
+}
+
+// write writes and flushes a reply. Replies are dropped after a write error,
+// e.g. once the client is gone.
+func (s *replyStream) write(reply reflect.Value) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return
+	}
+	if s.err = s.writer.WriteStreamReply(s.w, reply.Interface()); s.err != nil {
+		return
+	}
+	s.count++
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// call calls a streaming method, passing it a channel or callback of the
+// given type as its argument at index, and writes the replies until it
+// returns.
+func (s *replyStream) call(fn reflect.Value, in []reflect.Value, index int, streamType reflect.Type) []reflect.Value {
+	if streamType.Kind() == reflect.Func {
+		in[index] = reflect.MakeFunc(streamType, func(args []reflect.Value) []reflect.Value {
+			s.write(args[0])
+			return nil
+		})
+		return fn.Call(in)
+	}
+	ch := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, streamType.Elem()), 0)
+	in[index] = ch
+	var out []reflect.Value
+	var p interface{}
+	done := make(chan struct{})
+	go func() {
+		defer func() {
+			p = recover()
+			ch.Close()
+			close(done)
+		}()
+		out = fn.Call(in)
+	}()
+	for {
+		reply, ok := ch.Recv()
+		if !ok {
+			break
+		}
+		s.write(reply)
+	}
+	<-done
+	if out == nil {
+		// Panics are raised again in the serving goroutine.
+		panic(p)
+	}
+	return out
+}
@@ -0,0 +1,110 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type CountArgs struct {
+	N    int
+	Fail bool
+}
+
+type CountReply struct {
+	I int
+}
+
+type CountService struct{}
+
+func (s *CountService) Chan(r *http.Request, args *CountArgs, replies chan<- *CountReply) error {
+	for i := 0; i < args.N; i++ {
+		replies <- &CountReply{I: i}
+	}
+	if args.Fail {
+		return errors.New("count failed")
+	}
+	return nil
+}
+
+func (s *CountService) Func(r *http.Request, args *CountArgs, send func(*CountReply)) error {
+	for i := 0; i < args.N; i++ {
+		send(&CountReply{I: i})
+	}
+	if args.Fail {
+		return errors.New("count failed")
+	}
+	return nil
+}
+
+// streamLines decodes the newline-delimited responses of a stream.
+func streamLines(t *testing.T, w *httptest.ResponseRecorder) []*response {
+	t.Helper()
+	var lines []*response
+	scanner := bufio.NewScanner(strings.NewReader(w.Body.String()))
+	for scanner.Scan() {
+		res := new(response)
+		if err := json.Unmarshal(scanner.Bytes(), res); err != nil {
+			t.Fatalf("line %q is not JSON: %v", scanner.Text(), err)
+		}
+		lines = append(lines, res)
+	}
+	return lines
+}
+
+func TestStream(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(CountService), ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{"CountService.Chan", "CountService.Func"} {
+		w := call(s, method, []CountArgs{{N: 3}})
+		expect(t, w, http.StatusOK)
+		if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("%s: Content-Type = %q", method, ct)
+		}
+		lines := streamLines(t, w)
+		if len(lines) != 3 {
+			t.Fatalf("%s: %d lines, want 3: %s", method, len(lines), w.Body.String())
+		}
+		for i, res := range lines {
+			if !jsonEqual(res.Result, fmt.Sprintf(`{"I":%d}`, i)) {
+				t.Errorf("%s: line %d result = %s", method, i, res.Result)
+			}
+		}
+	}
+}
+
+func TestStreamError(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(CountService), ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{"CountService.Chan", "CountService.Func"} {
+		// The error ends the stream once replies are written.
+		w := call(s, method, []CountArgs{{N: 2, Fail: true}})
+		expect(t, w, http.StatusOK)
+		lines := streamLines(t, w)
+		if len(lines) != 3 {
+			t.Fatalf("%s: %d lines, want 3: %s", method, len(lines), w.Body.String())
+		}
+		if last := lines[2]; !jsonEqual(last.Error, `"count failed"`) {
+			t.Errorf("%s: last line error = %s", method, last.Error)
+		}
+
+		// Without replies, the error is a regular response.
+		expectError(t, call(s, method, []CountArgs{{Fail: true}}), http.StatusBadRequest, "count failed")
+		// As is an empty stream.
+		expect(t, call(s, method, []CountArgs{{}}), http.StatusOK)
+	}
+}
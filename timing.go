@@ -0,0 +1,67 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// timings records the duration of the phases of a request. They are
+// reported in the Server-Timing header when the request carries the
+// "X-RPC-Debug-Timing: 1" header, or in a Server-Timing trailer for
+// ReaderReply replies, which are streamed.
+type timings struct {
+	decode   time.Duration
+	validate time.Duration
+	invoke   time.Duration
+	encode   time.Duration
+}
+
+// String formats the timings as a Server-Timing header value, with
+// durations in milliseconds.
+func (t *timings) String() string {
+	return fmt.Sprintf("decode;dur=%.3f, validate;dur=%.3f, invoke;dur=%.3f, encode;dur=%.3f",
+		milliseconds(t.decode), milliseconds(t.validate),
+		milliseconds(t.invoke), milliseconds(t.encode))
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// bufferedResponse is an http.ResponseWriter holding the status and body in
+// memory, so that headers can still be added once the response is encoded.
+// Headers are shared with the wrapped ResponseWriter.
+type bufferedResponse struct {
+	w      http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.w.Header()
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+// flush writes the buffered response to the wrapped ResponseWriter.
+func (b *bufferedResponse) flush() {
+	b.WriteHeader(http.StatusOK)
+	b.w.WriteHeader(b.status)
+	b.w.Write(b.body.Bytes())
+}
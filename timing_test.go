@@ -0,0 +1,29 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"regexp"
+	"testing"
+)
+
+var serverTiming = regexp.MustCompile(`^decode;dur=[0-9.]+, validate;dur=[0-9.]+, invoke;dur=[0-9.]+, encode;dur=[0-9.]+$`)
+
+func TestServerTiming(t *testing.T) {
+	s := newServer(t)
+	w := call(s, "HelloService.Say", []HelloArgs{{Who: "a"}})
+	if timing := w.Header().Get("Server-Timing"); timing != "" {
+		t.Errorf("Server-Timing = %q without X-RPC-Debug-Timing", timing)
+	}
+
+	r := serveRequest("POST", "/rpc", `{"method":"HelloService.Say","params":[{"Who":"a"}]}`)
+	r.Header.Set("X-RPC-Debug-Timing", "1")
+	w = serveHTTP(s, r)
+	expectResult(t, w, `{"Message":"Hello, a!"}`)
+	if timing := w.Header().Get("Server-Timing"); !serverTiming.MatchString(timing) {
+		t.Errorf("Server-Timing = %q", timing)
+	}
+}
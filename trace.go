@@ -0,0 +1,95 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// traceContext holds the W3C trace context of a request.
+type traceContext struct {
+	traceID string
+	spanID  string
+	state   string
+}
+
+// TraceContextFromRequest returns the trace and parent span ids of the W3C
+// traceparent header of a request, as lowercase hex strings. Both are empty
+// if the header is missing or invalid.
+func TraceContextFromRequest(r *http.Request) (traceID, spanID string) {
+	if tc, ok := r.Context().Value(traceContextKey).(*traceContext); ok {
+		return tc.traceID, tc.spanID
+	}
+	return parseTraceparent(r.Header.Get("traceparent"))
+}
+
+// TraceID returns the trace id of the W3C traceparent header of the request
+// whose context is ctx, as a lowercase hex string, or an empty string.
+func TraceID(ctx context.Context) string {
+	if tc, ok := ctx.Value(traceContextKey).(*traceContext); ok {
+		return tc.traceID
+	}
+	return ""
+}
+
+// TraceState returns the W3C tracestate header of the request whose context
+// is ctx, when it also carries a valid traceparent header, so that it can
+// be propagated to outgoing requests.
+func TraceState(ctx context.Context) string {
+	if tc, ok := ctx.Value(traceContextKey).(*traceContext); ok {
+		return tc.state
+	}
+	return ""
+}
+
+// withTraceContext adds the trace context of a request to its context, if
+// the request carries a valid traceparent header.
+func withTraceContext(r *http.Request) *http.Request {
+	traceID, spanID := parseTraceparent(r.Header.Get("traceparent"))
+	if traceID == "" {
+		return r
+	}
+	tc := &traceContext{
+		traceID: traceID,
+		spanID:  spanID,
+		state:   strings.Join(r.Header.Values("tracestate"), ","),
+	}
+	return r.WithContext(context.WithValue(r.Context(), traceContextKey, tc))
+}
+
+// parseTraceparent parses a traceparent header value, made of the version,
+// the trace id, the parent span id and the flags, as in
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func parseTraceparent(value string) (traceID, spanID string) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || !isHex(parts[0], 2) || parts[0] == "ff" ||
+		!isHex(parts[1], 32) || !isHex(parts[2], 16) || !isHex(parts[3], 2) {
+		return "", ""
+	}
+	// Version 00 has exactly four fields; later versions may add more.
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", ""
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", ""
+	}
+	return parts[1], parts[2]
+}
+
+// isHex returns true if s is made of n lowercase hex digits.
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !('0' <= s[i] && s[i] <= '9' || 'a' <= s[i] && s[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shridarpatil/rpc"
+)
+
+const (
+	testTraceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID      = "00f067aa0ba902b7"
+	testTraceparent = "00-" + testTraceID + "-" + testSpanID + "-01"
+)
+
+func TestTraceContextFromRequest(t *testing.T) {
+	tests := []struct {
+		header  string
+		traceID string
+		spanID  string
+	}{
+		{testTraceparent, testTraceID, testSpanID},
+		{" " + testTraceparent + " ", testTraceID, testSpanID},
+		// Later versions may add fields.
+		{"01-" + testTraceID + "-" + testSpanID + "-01-extra", testTraceID, testSpanID},
+		{"", "", ""},
+		{"00-" + testTraceID + "-" + testSpanID + "-01-extra", "", ""},
+		{"ff-" + testTraceID + "-" + testSpanID + "-01", "", ""},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-" + testSpanID + "-01", "", ""},
+		{"00-00000000000000000000000000000000-" + testSpanID + "-01", "", ""},
+		{"00-" + testTraceID + "-0000000000000000-01", "", ""},
+		{"00-" + testTraceID + "-" + testSpanID, "", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/rpc", nil)
+		if test.header != "" {
+			r.Header.Set("traceparent", test.header)
+		}
+		traceID, spanID := rpc.TraceContextFromRequest(r)
+		if traceID != test.traceID || spanID != test.spanID {
+			t.Errorf("%q: ids = %q, %q, want %q, %q", test.header, traceID, spanID, test.traceID, test.spanID)
+		}
+	}
+}
+
+type TraceService struct{}
+
+func (s *TraceService) Get(ctx context.Context, args *HelloArgs, reply *HelloReply) error {
+	reply.Message = rpc.TraceID(ctx) + " " + rpc.TraceState(ctx)
+	return nil
+}
+
+func TestTraceContext(t *testing.T) {
+	s := newServer(t)
+	if err := s.RegisterService(new(TraceService), ""); err != nil {
+		t.Fatal(err)
+	}
+	body := `{"method":"TraceService.Get","params":[{}]}`
+	r := serveRequest("POST", "/rpc", body)
+	r.Header.Set("traceparent", testTraceparent)
+	r.Header.Add("tracestate", "a=1")
+	r.Header.Add("tracestate", "b=2")
+	w := serveHTTP(s, r)
+	expectResult(t, w, `{"Message":"`+testTraceID+` a=1,b=2"}`)
+	if id := w.Header().Get("X-Trace-Id"); id != "" {
+		t.Errorf("X-Trace-Id = %q without request ids", id)
+	}
+	// The trace state is dropped without a valid testTraceparent.
+	r = serveRequest("POST", "/rpc", body)
+	r.Header.Set("tracestate", "a=1")
+	expectResult(t, serveHTTP(s, r), `{"Message":" "}`)
+}
+
+func TestTraceIDHeader(t *testing.T) {
+	s := newServer(t)
+	s.EnableSequentialRequestIDs("node1")
+	r := serveRequest("POST", "/rpc", `{"method":"HelloService.Say","params":[{}]}`)
+	r.Header.Set("traceparent", testTraceparent)
+	w := serveHTTP(s, r)
+	if id := w.Header().Get("X-Request-Id"); id != "node1-1" {
+		t.Errorf("X-Request-Id = %q", id)
+	}
+	if id := w.Header().Get("X-Trace-Id"); id != testTraceID {
+		t.Errorf("X-Trace-Id = %q, want %q", id, testTraceID)
+	}
+	w = call(s, "HelloService.Say", []HelloArgs{{}})
+	if id := w.Header().Get("X-Trace-Id"); id != "" {
+		t.Errorf("X-Trace-Id = %q without a trace context", id)
+	}
+}